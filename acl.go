@@ -0,0 +1,193 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Access to webs and pages is controlled by preferences listing the users
+// and groups allowed or denied it, such as
+// "   * Set ALLOWWEBCHANGE = DesignGroup, JaneDoe". A page's ALLOWTOPIC and
+// DENYTOPIC preferences take precedence over the ALLOWWEB and DENYWEB
+// preferences of its web, and creating webs is controlled by ALLOWROOTCHANGE
+// and DENYROOTCHANGE in Main.WikiPreferences.
+const (
+	ViewAccess   = "VIEW"
+	ChangeAccess = "CHANGE"
+)
+
+// Groups are pages in the users web named like AdminGroup, with their
+// members set in the GROUP preference. Members of the admin group are
+// allowed everything.
+const groupSuffix = "Group"
+const adminGroup = "AdminGroup"
+
+// Returns the user and group names in a preference value, separated by
+// commas or spaces and optionally qualified with the users web.
+func splitNames(value string) []string {
+	names := []string{}
+	for _, name := range strings.FieldsFunc(value, func(c rune) bool { return c == ',' || c == ' ' || c == '\t' }) {
+		names = append(names, strings.TrimPrefix(name, usersWeb+"."))
+	}
+	return names
+}
+
+// Returns the members of group, including the members of groups in it.
+func groupMembers(wikiRepository WikiRepository, group string, seen map[string]bool) map[string]bool {
+	members := map[string]bool{}
+	if seen[group] || !strings.HasSuffix(group, groupSuffix) || !validName.MatchString(group) {
+		return members
+	}
+	seen[group] = true
+	p, err := wikiRepository.ReadPage(usersWeb, group)
+	if err != nil {
+		return members
+	}
+	for _, name := range splitNames(parsePreferences(p.Body)["GROUP"]) {
+		members[name] = true
+		for member := range groupMembers(wikiRepository, name, seen) {
+			members[member] = true
+		}
+	}
+	return members
+}
+
+// Returns true if the user is one of names, or a member of a group in names.
+func isListed(wikiRepository WikiRepository, userName string, names []string) bool {
+	if userName == "" {
+		return false
+	}
+	for _, name := range names {
+		if name == userName || groupMembers(wikiRepository, name, map[string]bool{})[userName] {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies the allow and deny preferences named allow and deny, returning
+// whether they decided access and if it is permitted.
+func checkAccess(wikiRepository WikiRepository, userName string, preferences map[string]string, allow string, deny string) (bool, bool) {
+	if isListed(wikiRepository, userName, splitNames(preferences[deny])) {
+		return true, false
+	}
+	if names := splitNames(preferences[allow]); len(names) > 0 {
+		return true, isListed(wikiRepository, userName, names)
+	}
+	return false, false
+}
+
+// Returns true if the user, "" when anonymous, has access to the page. When
+// title is empty access is to the web, and when web is also empty to
+// creating webs.
+func permitted(wikiRepository WikiRepository, userName string, web string, title string, access string) bool {
+	if isListed(wikiRepository, userName, []string{adminGroup}) {
+		return true
+	}
+	if access == ChangeAccess && web == usersWeb && title != "" && title != userName {
+		// user pages hold credentials, only their user can change them
+		if _, _, err := loadUser(wikiRepository, title); err == nil {
+			return false
+		}
+	}
+	if web == "" {
+		decided, allowed := checkAccess(wikiRepository, userName, readPreferences(wikiRepository, "", ""),
+			"ALLOWROOT"+access, "DENYROOT"+access)
+		return !decided || allowed
+	}
+	if title != "" {
+		if p, err := wikiRepository.ReadPage(web, title); err == nil {
+			decided, allowed := checkAccess(wikiRepository, userName, parsePreferences(p.Body),
+				"ALLOWTOPIC"+access, "DENYTOPIC"+access)
+			if decided {
+				return allowed
+			}
+		}
+	}
+	decided, allowed := checkAccess(wikiRepository, userName, readPreferences(wikiRepository, web, ""),
+		"ALLOWWEB"+access, "DENYWEB"+access)
+	return !decided || allowed
+}
+
+// Returns the name of the user making the request for access control, the
+// logged in user or a user named by the trusted proxy header.
+func (w *Wiki) requestUserName(r *http.Request) string {
+	if user := requestUser(r); user != nil {
+		return user.Name
+	}
+	if w.AuthorHeader != "" {
+		if author := parseAuthor(r.Header.Get(w.AuthorHeader)); author != nil && isUserName(author.Name) {
+			return author.Name
+		}
+	}
+	return ""
+}
+
+// Returns true if the request has access to the page, including the pages
+// of webs and pages requiring authentication listed in searches, changes
+// and indexes.
+func (w *Wiki) allowed(r *http.Request, web string, title string, access string) bool {
+	if !w.authenticated(r) && preferenceEnabled(readPreferences(w.Repository, web, title), "RequireAuthentication", false) {
+		return false
+	}
+	return permitted(w.Repository, w.requestUserName(r), web, title, access)
+}
+
+// Checks the request has access to the page, otherwise sending anonymous
+// users to log in and refusing everyone else.
+func (w *Wiki) authorize(rw http.ResponseWriter, r *http.Request, web string, title string, access string) bool {
+	if w.allowed(r, web, title, access) {
+		return true
+	}
+	if w.requestUserName(r) == "" && r.Method == "GET" {
+		http.Redirect(rw, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return false
+	}
+	http.Error(rw, "You do not have access to "+strings.ToLower(access)+" this page.", http.StatusForbidden)
+	return false
+}
+
+// Returns the pages in refs the request can view.
+func (w *Wiki) viewablePages(r *http.Request, refs []PageReference) []PageReference {
+	viewable := []PageReference{}
+	for _, ref := range refs {
+		if w.allowed(r, ref.Web, ref.Title, ViewAccess) {
+			viewable = append(viewable, ref)
+		}
+	}
+	return viewable
+}
+
+// Returns the changes in changes to pages the request can view.
+func (w *Wiki) viewableChanges(r *http.Request, changes []*Change) []*Change {
+	viewable := []*Change{}
+	for _, change := range changes {
+		if w.allowed(r, change.Web, change.Title, ViewAccess) {
+			viewable = append(viewable, change)
+		}
+	}
+	return viewable
+}
+
+// Returns the search results for pages the request can view.
+func (w *Wiki) viewableResults(r *http.Request, results []*SearchResult) []*SearchResult {
+	viewable := []*SearchResult{}
+	for _, result := range results {
+		if w.allowed(r, result.Page.Web, result.Page.Title, ViewAccess) {
+			viewable = append(viewable, result)
+		}
+	}
+	return viewable
+}
+
+// Returns the pages in the trash from webs the request can view.
+func (w *Wiki) viewableTrash(r *http.Request, trash []*TrashedPage) []*TrashedPage {
+	viewable := []*TrashedPage{}
+	for _, p := range trash {
+		if w.allowed(r, p.Web, "", ViewAccess) {
+			viewable = append(viewable, p)
+		}
+	}
+	return viewable
+}
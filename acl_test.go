@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func createTestACLRepository(t *testing.T) *FileWikiRepository {
+	return createTestFileWikiRepository(t, map[string]string{
+		"Main/WebHome.md":           "Home",
+		"Main/AdminGroup.md":        "   * Set GROUP = RootUser\n",
+		"Main/JaneDoe.md":           "---\npassword: hash\n---\nJane",
+		"Main/DesignGroup.md":       "   * Set GROUP = Main.JaneDoe, ContractorGroup\n",
+		"Main/ContractorGroup.md":   "   * Set GROUP = JohnSmith, DesignGroup\n",
+		"Design/WebPreferences.md":  "   * Set ALLOWWEBVIEW = DesignGroup\n   * Set ALLOWWEBCHANGE = DesignGroup\n",
+		"Design/WebHome.md":         "Design",
+		"Design/Roadmap.md":         "   * Set DENYTOPICVIEW = JohnSmith\n",
+		"Design/PublicNotes.md":     "   * Set ALLOWTOPICVIEW = JaneDoe, OtherUser\n",
+		"Sandbox/WebPreferences.md": "   * Set DENYWEBCHANGE = OtherUser\n",
+		"Sandbox/WebHome.md":        "Sandbox",
+		"Secret/WebPreferences.md":  "   * Set ALLOWWEBVIEW = DesignGroup\n",
+		"Secret/Plans.md":           "Secret plans",
+	})
+}
+
+func TestGroupMembers(t *testing.T) {
+	r := createTestACLRepository(t)
+	defer os.RemoveAll(r.Root)
+	members := groupMembers(r, "DesignGroup", map[string]bool{})
+	for _, name := range []string{"JaneDoe", "JohnSmith", "ContractorGroup"} {
+		if !members[name] {
+			t.Errorf("expected %s to be a member of DesignGroup in '%v'", name, members)
+		}
+	}
+	if len(groupMembers(r, "JaneDoe", map[string]bool{})) != 0 {
+		t.Errorf("expected a user not to have members")
+	}
+}
+
+func TestPermitted(t *testing.T) {
+	r := createTestACLRepository(t)
+	defer os.RemoveAll(r.Root)
+	checks := []struct {
+		user, web, title, access string
+		expected                 bool
+	}{
+		{"", "Main", "WebHome", ViewAccess, true},
+		{"OtherUser", "Main", "WebHome", ChangeAccess, true},
+		{"", "Design", "WebHome", ViewAccess, false},
+		{"OtherUser", "Design", "WebHome", ViewAccess, false},
+		{"JaneDoe", "Design", "WebHome", ChangeAccess, true},
+		{"JohnSmith", "Design", "WebHome", ViewAccess, true},
+		{"JohnSmith", "Design", "Roadmap", ViewAccess, false},
+		{"OtherUser", "Design", "PublicNotes", ViewAccess, true},
+		{"OtherUser", "Design", "PublicNotes", ChangeAccess, false},
+		{"RootUser", "Design", "Roadmap", ChangeAccess, true},
+		{"OtherUser", "Sandbox", "WebHome", ViewAccess, true},
+		{"OtherUser", "Sandbox", "WebHome", ChangeAccess, false},
+		{"JaneDoe", "Design", "", ViewAccess, true},
+		{"JaneDoe", "", "", ChangeAccess, true},
+		{"", "Main", "JaneDoe", ViewAccess, true},
+		{"OtherUser", "Main", "JaneDoe", ChangeAccess, false},
+		{"JaneDoe", "Main", "JaneDoe", ChangeAccess, true},
+		{"RootUser", "Main", "JaneDoe", ChangeAccess, true},
+	}
+	for _, c := range checks {
+		if allowed := permitted(r, c.user, c.web, c.title, c.access); allowed != c.expected {
+			t.Errorf("expected %v for %s to %s %s.%s got %v", c.expected, c.user, c.access, c.web, c.title, allowed)
+		}
+	}
+}
+
+func TestAuthorizeHandlers(t *testing.T) {
+	r := createTestACLRepository(t)
+	defer os.RemoveAll(r.Root)
+	renderer := NewTemplateRenderer("tmpl", "default")
+	wiki := &Wiki{Repository: r, PageRenderer: renderer, Webs: r.LoadWebs()}
+
+	req, _ := http.NewRequest("GET", "/view/Design/WebHome", nil)
+	rr := httptest.NewRecorder()
+	makeHandler(viewHandler, wiki, r, renderer).ServeHTTP(rr, req)
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/login?next=%2Fview%2FDesign%2FWebHome" {
+		t.Errorf("expected anonymous user to be sent to log in got %v '%s'", rr.Code, rr.Header().Get("Location"))
+	}
+
+	req, _ = http.NewRequest("POST", "/save/Design/WebHome", nil)
+	rr = httptest.NewRecorder()
+	makeSaveHandler(saveHandler, wiki, r).ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected %v got %v", http.StatusForbidden, rr.Code)
+	}
+
+	// changing a web restricted only to view still needs viewing it
+	req, _ = http.NewRequest("GET", "/edit/Secret/Plans", nil)
+	rr = httptest.NewRecorder()
+	makeHandler(editHandler, wiki, r, renderer).ServeHTTP(rr, req)
+	if rr.Code != http.StatusFound || strings.Contains(rr.Body.String(), "Secret plans") {
+		t.Errorf("expected anonymous user to be sent to log in got %v '%s'", rr.Code, rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("POST", "/save/Secret/Plans", strings.NewReader("body=Mine&revision=stale"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	makeSaveHandler(saveHandler, wiki, r).ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden || strings.Contains(rr.Body.String(), "Secret plans") {
+		t.Errorf("expected %v got %v '%s'", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/search?q=Design", nil)
+	rr = httptest.NewRecorder()
+	makeWikiHandler(searchHandler, wiki, r, renderer).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || wiki.allowed(req, "Design", "WebHome", ViewAccess) {
+		t.Errorf("expected search to succeed without access to Design")
+	}
+	for _, result := range wiki.viewableResults(req, r.Search("Design", "")) {
+		if result.Page.Web == "Design" {
+			t.Errorf("expected results from Design to be hidden got '%s'", result.Page.String())
+		}
+	}
+}
+
+func TestRequireAuthenticationInLists(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/WebHome.md":           "Home",
+		"Private/WebPreferences.md": "   * Set RequireAuthentication = on\n",
+		"Private/Plans.md":          "Plans",
+	})
+	defer os.RemoveAll(r.Root)
+	wiki := &Wiki{Repository: r}
+	wiki.Sessions, _ = NewSessions("secret")
+	refs := []PageReference{{Web: "Main", Title: "WebHome"}, {Web: "Private", Title: "Plans"}}
+
+	req, _ := http.NewRequest("GET", "/search?q=Plans", nil)
+	if viewable := wiki.viewablePages(req, refs); len(viewable) != 1 || viewable[0].Web != "Main" {
+		t.Errorf("expected anonymous users not to see pages requiring authentication got '%v'", viewable)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, &User{Name: "JaneDoe"}))
+	if viewable := wiki.viewablePages(req, refs); len(viewable) != 2 {
+		t.Errorf("expected logged in users to see all pages got '%v'", viewable)
+	}
+}
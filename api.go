@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A page as read and written by the JSON API. Revision is the revision the
+// page was read at, and must be sent back to update it.
+type apiPage struct {
+	Web      string                 `json:"web"`
+	Title    string                 `json:"title"`
+	Body     string                 `json:"body"`
+	Meta     map[string]interface{} `json:"meta"`
+	Revision string                 `json:"revision"`
+	// Used as the commit message when writing the page.
+	Summary string `json:"summary,omitempty"`
+}
+
+type apiWeb struct {
+	Name string `json:"name"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+	// The page as it is now, when an update conflicts with it and the
+	// request can view it.
+	Current *apiPage `json:"current,omitempty"`
+}
+
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Error(err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, &apiError{Error: message})
+}
+
+func newAPIPage(web string, p *Page) *apiPage {
+	p = withoutProtectedMeta(p)
+	meta := p.Meta
+	if meta == nil {
+		meta = map[string]interface{}{}
+	}
+	return &apiPage{Web: web, Title: p.Title, Body: string(p.Body), Meta: meta, Revision: p.Revision}
+}
+
+// Checks the request has access to the page, answering with a JSON error
+// otherwise.
+func (w *Wiki) authorizeAPI(rw http.ResponseWriter, r *http.Request, web string, title string, access string) bool {
+	if w.allowed(r, web, title, access) {
+		return true
+	}
+	if w.requestUserName(r) == "" {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSONError(rw, http.StatusUnauthorized, "Authentication required")
+		return false
+	}
+	writeJSONError(rw, http.StatusForbidden, "You do not have access to "+strings.ToLower(access)+" this page.")
+	return false
+}
+
+// Returns the web and title of an API request for a page, answering with a
+// JSON error if they are not valid.
+func apiPageName(w http.ResponseWriter, r *http.Request, wiki *Wiki) (string, string, bool) {
+	web := r.URL.Query().Get(":web")
+	title := r.URL.Query().Get(":title")
+	if _, ok := wiki.Webs[web]; !ok {
+		writeJSONError(w, http.StatusNotFound, "There is no web '"+web+"'.")
+		return "", "", false
+	}
+	if !validName.MatchString(title) {
+		writeJSONError(w, http.StatusBadRequest, "Bad Page Name "+title)
+		return "", "", false
+	}
+	return web, title, true
+}
+
+func apiWebsHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	webs := []*apiWeb{}
+	for name := range wiki.Webs {
+		if wiki.allowed(r, name, "", ViewAccess) {
+			webs = append(webs, &apiWeb{Name: name})
+		}
+	}
+	sort.Slice(webs, func(i, j int) bool { return webs[i].Name < webs[j].Name })
+	writeJSON(w, http.StatusOK, webs)
+}
+
+func apiCreateWebHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	if !wiki.authorizeAPI(w, r, "", "", ChangeAccess) {
+		return
+	}
+	var web apiWeb
+	if err := json.NewDecoder(r.Body).Decode(&web); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if !validWeb.MatchString(web.Name) {
+		writeJSONError(w, http.StatusBadRequest, "Bad Web Name "+web.Name)
+		return
+	}
+	if _, ok := wiki.Webs[web.Name]; ok {
+		writeJSONError(w, http.StatusConflict, "Web '"+web.Name+"' already exists.")
+		return
+	}
+	webDefinition, err := wikiRepository.CreateWeb(web.Name, wiki.requestAuthor(r))
+	if err != nil {
+		log.Error(err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	wiki.Webs[webDefinition.Name] = webDefinition
+	w.Header().Set("Location", "/api/v1/webs/"+webDefinition.Name+"/pages/WebHome")
+	writeJSON(w, http.StatusCreated, &apiWeb{Name: webDefinition.Name})
+}
+
+func apiPageHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	web, title, ok := apiPageName(w, r, wiki)
+	if !ok || !wiki.authorizeAPI(w, r, web, title, ViewAccess) {
+		return
+	}
+	p, err := loadPage(wikiRepository, web, title)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "There is no page '"+web+"."+title+"'.")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIPage(web, p))
+}
+
+// Creates or updates the page. Updating a page needs the revision it was
+// read at, and fails with 409 Conflict if it was changed since in a way
+// that could not be merged.
+func apiSavePageHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	web, title, ok := apiPageName(w, r, wiki)
+	if !ok || !wiki.authorizeAPI(w, r, web, title, ChangeAccess) {
+		return
+	}
+	var page apiPage
+	if err := json.NewDecoder(r.Body).Decode(&page); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if page.Title != "" && page.Title != title {
+		writeJSONError(w, http.StatusBadRequest, "The title '"+page.Title+"' does not match the URL.")
+		return
+	}
+	_, err := wikiRepository.ReadPage(web, title)
+	created := os.IsNotExist(err)
+
+	p := &Page{Title: title, Body: []byte(page.Body), Meta: page.Meta, Revision: page.Revision}
+	keepProtectedMeta(wikiRepository, web, p)
+	err = p.save(wikiRepository, web, &Edit{Summary: page.Summary, Author: wiki.requestAuthor(r)})
+	if conflict, ok := err.(*EditConflictError); ok {
+		response := &apiError{Error: conflict.Error()}
+		if wiki.allowed(r, web, title, ViewAccess) {
+			response.Current = newAPIPage(web, conflict.Current)
+		}
+		writeJSON(w, http.StatusConflict, response)
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		w.Header().Set("Location", r.URL.Path)
+	}
+	writeJSON(w, status, newAPIPage(web, p))
+}
+
+func apiDeletePageHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	web, title, ok := apiPageName(w, r, wiki)
+	if !ok || !wiki.authorizeAPI(w, r, web, title, ChangeAccess) {
+		return
+	}
+	if _, err := wikiRepository.ReadPage(web, title); err != nil {
+		writeJSONError(w, http.StatusNotFound, "There is no page '"+web+"."+title+"'.")
+		return
+	}
+	err := wikiRepository.DeletePage(web, title, wiki.requestAuthor(r))
+	if err != nil {
+		log.Error(err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
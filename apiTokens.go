@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// API tokens authenticate scripts as their owner. A token is
+// "<UserName>.<id>.<secret>", and only the sha256 of its secret is kept in
+// the tokens metadata of the user page, keyed by id.
+type APIToken struct {
+	Id      string
+	Name    string
+	Created time.Time
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashAPITokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func userTokens(p *Page) map[string]interface{} {
+	tokens, _ := p.Meta["tokens"].(map[string]interface{})
+	if tokens == nil {
+		tokens = map[string]interface{}{}
+	}
+	return tokens
+}
+
+// Lists the tokens on a user page, oldest first.
+func listAPITokens(p *Page) []*APIToken {
+	tokens := []*APIToken{}
+	for id, value := range userTokens(p) {
+		entry, _ := value.(map[string]interface{})
+		name, _ := entry["name"].(string)
+		created, _ := entry["created"].(string)
+		when, _ := time.Parse(time.RFC3339, created)
+		tokens = append(tokens, &APIToken{Id: id, Name: name, Created: when})
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+	return tokens
+}
+
+// Creates a token for the user, returning it. The token can not be
+// recovered later, only revoked.
+func createAPIToken(wikiRepository WikiRepository, user *User, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("Tokens need a name, such as the job using them.")
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	_, p, err := loadUser(wikiRepository, user.Name)
+	if err != nil {
+		return "", err
+	}
+	tokens := userTokens(p)
+	tokens[id] = map[string]interface{}{
+		"name":    name,
+		"hash":    hashAPITokenSecret(secret),
+		"created": time.Now().UTC().Format(time.RFC3339),
+	}
+	p.Meta["tokens"] = tokens
+	err = p.save(wikiRepository, usersWeb, &Edit{Summary: "Created API token " + id + " for " + user.Name, Author: user.Author()})
+	if err != nil {
+		return "", err
+	}
+	return user.Name + "." + id + "." + secret, nil
+}
+
+func revokeAPIToken(wikiRepository WikiRepository, user *User, id string) error {
+	_, p, err := loadUser(wikiRepository, user.Name)
+	if err != nil {
+		return err
+	}
+	tokens := userTokens(p)
+	if _, ok := tokens[id]; !ok {
+		return errors.New("There is no token '" + id + "'.")
+	}
+	delete(tokens, id)
+	if len(tokens) == 0 {
+		delete(p.Meta, "tokens")
+	}
+	return p.save(wikiRepository, usersWeb, &Edit{Summary: "Revoked API token " + id + " for " + user.Name, Author: user.Author()})
+}
+
+// Returns the owner of the token.
+func authenticateAPIToken(wikiRepository WikiRepository, token string) (*User, error) {
+	invalid := errors.New("Invalid API token.")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid
+	}
+	user, p, err := loadUser(wikiRepository, parts[0])
+	if err != nil {
+		return nil, invalid
+	}
+	entry, _ := userTokens(p)[parts[1]].(map[string]interface{})
+	hash, _ := entry["hash"].(string)
+	if hash == "" || subtle.ConstantTimeCompare([]byte(hash), []byte(hashAPITokenSecret(parts[2]))) != 1 {
+		return nil, invalid
+	}
+	return user, nil
+}
+
+// Returns the token in the request's "Authorization: Bearer" header, if any.
+func bearerToken(r *http.Request) (string, bool) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[7:]), true
+}
+
+// Requires a logged in user, sending anyone else to log in.
+func settingsUser(w http.ResponseWriter, r *http.Request) *User {
+	user := requestUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/login?next=/settings", http.StatusFound)
+	}
+	return user
+}
+
+func renderSettings(w http.ResponseWriter, r *http.Request, wiki *Wiki, templateRenderer *TemplateRenderer,
+	user *User, data map[string]interface{}) {
+	_, p, err := loadUser(wiki.Repository, user.Name)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["Tokens"] = listAPITokens(p)
+	renderTemplate(w, r, templateRenderer, "settings", wiki, usersWeb, &Page{Title: "Settings"}, data)
+}
+
+func settingsHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	if user := settingsUser(w, r); user != nil {
+		renderSettings(w, r, wiki, templateRenderer, user, nil)
+	}
+}
+
+func createTokenHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	user := settingsUser(w, r)
+	if user == nil {
+		return
+	}
+	token, err := createAPIToken(wikiRepository, user, r.FormValue("name"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		renderSettings(w, r, wiki, templateRenderer, user, map[string]interface{}{"Error": err.Error()})
+		return
+	}
+	renderSettings(w, r, wiki, templateRenderer, user, map[string]interface{}{"NewToken": token})
+}
+
+func revokeTokenHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	user := settingsUser(w, r)
+	if user == nil {
+		return
+	}
+	err := revokeAPIToken(wikiRepository, user, r.FormValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		renderSettings(w, r, wiki, templateRenderer, user, map[string]interface{}{"Error": err.Error()})
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusFound)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAPITokens(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{"Main/WebHome.md": "Home"})
+	defer os.RemoveAll(r.Root)
+	defer drainGitWorkQueue()
+	user, err := registerUser(r, "JaneDoe", "jane@example.com", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := createAPIToken(r, user, "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := r.ReadPage("Main", "JaneDoe")
+	if source, _ := pageSource(p); strings.Contains(string(source), strings.Split(token, ".")[2]) {
+		t.Errorf("expected the token secret not to be stored")
+	}
+	tokens := listAPITokens(p)
+	if len(tokens) != 1 || tokens[0].Name != "ci" {
+		t.Fatalf("expected one token named '%s' got %v", "ci", tokens)
+	}
+
+	if owner, err := authenticateAPIToken(r, token); err != nil || owner.Name != "JaneDoe" {
+		t.Errorf("expected token to authenticate JaneDoe got '%v'", err)
+	}
+	if _, err := authenticateAPIToken(r, token+"x"); err == nil {
+		t.Errorf("expected a wrong secret to be refused")
+	}
+	if _, err := createAPIToken(r, user, " "); err == nil {
+		t.Errorf("expected a token without a name to be refused")
+	}
+
+	if err := revokeAPIToken(r, user, tokens[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticateAPIToken(r, token); err == nil {
+		t.Errorf("expected a revoked token to be refused")
+	}
+	keepProtectedMeta(r, "Main", p)
+	if _, ok := p.Meta["tokens"]; ok {
+		t.Errorf("expected revoked tokens to stay revoked when an old revision is written back")
+	}
+}
+
+func TestBearerAuthentication(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/WikiPreferences.md": "   * Set RequireAuthentication = on\n",
+		"Main/WebHome.md":         "Home",
+	})
+	defer os.RemoveAll(r.Root)
+	defer drainGitWorkQueue()
+	user, _ := registerUser(r, "JaneDoe", "", "correct horse")
+	token, _ := createAPIToken(r, user, "ci")
+	wiki := &Wiki{Repository: r}
+	handler := wiki.withUser(wiki.withAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(wiki.requestAuthor(r).Name))
+	})))
+
+	requests := []struct {
+		authorization string
+		expected      int
+	}{
+		{"Bearer " + token, http.StatusOK},
+		{"bearer " + token, http.StatusOK},
+		{"Bearer JaneDoe.0000.secret", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+	for _, request := range requests {
+		req, _ := http.NewRequest("POST", "/save/Main/WebHome", nil)
+		if request.authorization != "" {
+			req.Header.Set("Authorization", request.authorization)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != request.expected {
+			t.Errorf("expected %v for '%s' got %v", request.expected, request.authorization, rr.Code)
+		}
+		if rr.Code == http.StatusOK && rr.Body.String() != "JaneDoe" {
+			t.Errorf("expected '%s' got '%s'", "JaneDoe", rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/bmizerany/pat"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPageAPI(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/WebHome.md": "Home",
+		"Main/Locked.md":  "   * Set ALLOWTOPICCHANGE = JohnSmith\n",
+		"Main/Hidden.md":  "   * Set ALLOWTOPICVIEW = JohnSmith\nHidden text",
+	})
+	defer os.RemoveAll(r.Root)
+	wiki := &Wiki{Repository: r, Webs: r.LoadWebs()}
+	m := pat.New()
+	m.Get("/api/v1/webs/:web/pages/:title", makeWikiHandler(apiPageHandler, wiki, r, nil))
+	m.Put("/api/v1/webs/:web/pages/:title", makeWikiHandler(apiSavePageHandler, wiki, r, nil))
+	m.Del("/api/v1/webs/:web/pages/:title", makeWikiHandler(apiDeletePageHandler, wiki, r, nil))
+
+	request := func(method string, path string, body string) (*httptest.ResponseRecorder, *apiPage) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		m.ServeHTTP(rr, req)
+		drainGitWorkQueue()
+		var page apiPage
+		json.Unmarshal(rr.Body.Bytes(), &page)
+		return rr, &page
+	}
+
+	rr, _ := request("PUT", "/api/v1/webs/Main/pages/TestResults", `{"body": "All passed", "meta": {"build": 42}}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected %v got %v '%s'", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	rr, page := request("GET", "/api/v1/webs/Main/pages/TestResults", "")
+	if rr.Code != http.StatusOK || page.Body != "All passed" || page.Meta["build"] != float64(42) || page.Revision == "" {
+		t.Fatalf("expected the created page got %v '%s'", rr.Code, rr.Body.String())
+	}
+
+	update, _ := json.Marshal(&apiPage{Body: "One failed", Revision: page.Revision})
+	if rr, _ = request("PUT", "/api/v1/webs/Main/pages/TestResults", string(update)); rr.Code != http.StatusOK {
+		t.Errorf("expected %v got %v '%s'", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr, _ = request("PUT", "/api/v1/webs/Main/pages/TestResults", string(update)); rr.Code != http.StatusConflict ||
+		!strings.Contains(rr.Body.String(), `"error"`) || !strings.Contains(rr.Body.String(), "One failed") {
+		t.Errorf("expected %v for a stale revision got %v '%s'", http.StatusConflict, rr.Code, rr.Body.String())
+	}
+	if rr, _ = request("PUT", "/api/v1/webs/Main/pages/Hidden", string(update)); rr.Code != http.StatusConflict ||
+		strings.Contains(rr.Body.String(), "Hidden text") {
+		t.Errorf("expected a conflict without the page it can not view got %v '%s'", rr.Code, rr.Body.String())
+	}
+
+	errors := []struct {
+		method, path string
+		expected     int
+	}{
+		{"GET", "/api/v1/webs/Main/pages/Missing", http.StatusNotFound},
+		{"GET", "/api/v1/webs/Nowhere/pages/WebHome", http.StatusNotFound},
+		{"PUT", "/api/v1/webs/Main/pages/Locked", http.StatusUnauthorized},
+		{"DELETE", "/api/v1/webs/Main/pages/Missing", http.StatusNotFound},
+	}
+	for _, e := range errors {
+		rr, _ := request(e.method, e.path, `{"body": "x"}`)
+		if rr.Code != e.expected || rr.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+			t.Errorf("expected %v for %s %s got %v '%s'", e.expected, e.method, e.path, rr.Code, rr.Body.String())
+		}
+	}
+
+	if rr, _ = request("DELETE", "/api/v1/webs/Main/pages/TestResults", ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected %v got %v '%s'", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+	if rr, _ = request("GET", "/api/v1/webs/Main/pages/TestResults", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected deleted page to be gone got %v", rr.Code)
+	}
+}
+
+func TestWebsAPI(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/WebHome.md":          "Home",
+		"Secret/WebPreferences.md": "   * Set ALLOWWEBVIEW = JohnSmith\n",
+	})
+	defer os.RemoveAll(r.Root)
+	wiki := &Wiki{Repository: r, Webs: r.LoadWebs()}
+
+	req, _ := http.NewRequest("GET", "/api/v1/webs", nil)
+	rr := httptest.NewRecorder()
+	makeWikiHandler(apiWebsHandler, wiki, r, nil).ServeHTTP(rr, req)
+	if body := strings.TrimSpace(rr.Body.String()); body != `[{"name":"Main"}]` {
+		t.Errorf("expected '%s' got '%s'", `[{"name":"Main"}]`, body)
+	}
+
+	for body, expected := range map[string]int{`{"name": "bad name"}`: http.StatusBadRequest, `{"name": "Main"}`: http.StatusConflict} {
+		req, _ = http.NewRequest("POST", "/api/v1/webs", strings.NewReader(body))
+		rr = httptest.NewRecorder()
+		makeWikiHandler(apiCreateWebHandler, wiki, r, nil).ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("expected %v for '%s' got %v", expected, body, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/mail"
+	"strings"
+)
+
+var guestAuthor = &Author{Name: "Guest User", Email: "guest@example.com"}
+
+// Parses an identity in the form "Name <email>", or a plain name.
+func parseAuthor(identity string) *Author {
+	identity = strings.TrimSpace(identity)
+	if identity == "" {
+		return nil
+	}
+	address, err := mail.ParseAddress(identity)
+	if err != nil {
+		return &Author{Name: identity}
+	}
+	if address.Name == "" {
+		return &Author{Name: address.Address, Email: address.Address}
+	}
+	return &Author{Name: address.Name, Email: address.Address}
+}
+
+// Returns the author of changes made by the request, taken from the trusted
+// header when one is configured, then the logged in user and HTTP basic auth
+// when a proxy checks it, falling back to the default author.
+func (w *Wiki) requestAuthor(r *http.Request) *Author {
+	if w.AuthorHeader != "" {
+		if author := parseAuthor(r.Header.Get(w.AuthorHeader)); author != nil {
+			return author
+		}
+	}
+	if user := requestUser(r); user != nil {
+		return user.Author()
+	}
+	if user, _, ok := r.BasicAuth(); ok && w.TrustBasicAuth {
+		if author := parseAuthor(user); author != nil {
+			return author
+		}
+	}
+	if w.DefaultAuthor != nil {
+		return w.DefaultAuthor
+	}
+	return guestAuthor
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseAuthor(t *testing.T) {
+	authors := []struct {
+		identity string
+		expected Author
+	}{
+		{"Jane Doe <jane@example.com>", Author{Name: "Jane Doe", Email: "jane@example.com"}},
+		{"jane@example.com", Author{Name: "jane@example.com", Email: "jane@example.com"}},
+		{"jane", Author{Name: "jane"}},
+	}
+	for _, a := range authors {
+		author := parseAuthor(a.identity)
+		if author == nil || *author != a.expected {
+			t.Errorf("expected '%v' got '%v'", a.expected, author)
+		}
+	}
+	if author := parseAuthor(" "); author != nil {
+		t.Errorf("expected no author got '%v'", author)
+	}
+}
+
+func TestRequestAuthor(t *testing.T) {
+	wiki := &Wiki{AuthorHeader: "X-Remote-User", DefaultAuthor: &Author{Name: "Anonymous"}}
+
+	req, _ := http.NewRequest("POST", "/save/Main/WebHome", nil)
+	if author := wiki.requestAuthor(req); author.Name != "Anonymous" {
+		t.Errorf("expected '%s' got '%s'", "Anonymous", author.Name)
+	}
+
+	req.SetBasicAuth("basic", "secret")
+	if author := wiki.requestAuthor(req); author.Name != "Anonymous" {
+		t.Errorf("expected unchecked basic auth to be ignored got '%s'", author.Name)
+	}
+
+	wiki.TrustBasicAuth = true
+	if author := wiki.requestAuthor(req); author.Name != "basic" {
+		t.Errorf("expected '%s' got '%s'", "basic", author.Name)
+	}
+
+	req.Header.Set("X-Remote-User", "Jane Doe <jane@example.com>")
+	if author := wiki.requestAuthor(req); author.Name != "Jane Doe" || author.Email != "jane@example.com" {
+		t.Errorf("expected '%s' got '%s'", "Jane Doe", author.Name)
+	}
+
+	wiki.AuthorHeader = ""
+	if author := wiki.requestAuthor(req); author.Name != "basic" {
+		t.Errorf("expected untrusted header to be ignored got '%s'", author.Name)
+	}
+}
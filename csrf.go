@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"net/http"
+	"strings"
+)
+
+// Forms carry a token tied to the browser's csrf cookie and its session,
+// so other sites can not make a logged in browser change the wiki.
+const csrfCookie = "csrf"
+
+// The form field and header the token is sent in.
+const csrfField = "csrf"
+const csrfHeader = "X-CSRF-Token"
+
+const csrfContextKey contextKey = "csrf"
+
+// Returns the token for forms in the response to the request, or "" if the
+// request did not pass through withCSRF.
+func (s *Sessions) csrfToken(r *http.Request) string {
+	id, _ := r.Context().Value(csrfContextKey).(string)
+	if s == nil || id == "" {
+		return ""
+	}
+	session := ""
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		session = cookie.Value
+	}
+	return s.sign("csrf|" + id + "|" + session)
+}
+
+// Returns the token sent with the request, from the header or form.
+func requestCSRFToken(rw http.ResponseWriter, r *http.Request) string {
+	if token := r.Header.Get(csrfHeader); token != "" {
+		return token
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		// read here, so the limit is the one for attachments
+		r.Body = http.MaxBytesReader(rw, r.Body, maxAttachmentSize)
+	}
+	return r.PostFormValue(csrfField)
+}
+
+func unsafeMethod(method string) bool {
+	return method != "GET" && method != "HEAD" && method != "OPTIONS"
+}
+
+// Sets the csrf cookie on browsers without one, and refuses requests that
+// change the wiki without the matching token. Requests authenticated by an
+// API token are not sent automatically by browsers, and need no token.
+func (w *Wiki) withCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if w.Sessions == nil {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if _, ok := bearerToken(r); ok {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		id := ""
+		if cookie, err := r.Cookie(csrfCookie); err == nil {
+			id = cookie.Value
+		}
+		if unsafeMethod(r.Method) {
+			r = r.WithContext(context.WithValue(r.Context(), csrfContextKey, id))
+			if id == "" || !hmac.Equal([]byte(requestCSRFToken(rw, r)), []byte(w.Sessions.csrfToken(r))) {
+				http.Error(rw, "The form has expired or was sent from another site, reload the page and try again.",
+					http.StatusForbidden)
+				return
+			}
+		}
+		if id == "" {
+			var err error
+			if id, err = randomHex(16); err != nil {
+				http.Error(rw, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(rw, &http.Cookie{
+				Name:     csrfCookie,
+				Value:    id,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), csrfContextKey, id)))
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSRF(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{"Main/WebHome.md": "Home"})
+	defer os.RemoveAll(r.Root)
+	defer drainGitWorkQueue()
+	wiki := &Wiki{Repository: r}
+	wiki.Sessions, _ = NewSessions("secret")
+	token := ""
+	handler := wiki.withUser(wiki.withCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = wiki.Sessions.csrfToken(r)
+	})))
+
+	req, _ := http.NewRequest("GET", "/edit/Main/WebHome", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookie || token == "" {
+		t.Fatalf("expected a csrf cookie and token got %v '%s'", cookies, token)
+	}
+	session := &http.Cookie{Name: sessionCookie, Value: wiki.Sessions.encode("JaneDoe", time.Now().Add(time.Hour))}
+
+	post := func(form url.Values, header http.Header, cookies ...*http.Cookie) int {
+		req, _ := http.NewRequest("POST", "/save/Main/WebHome", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k := range header {
+			req.Header.Set(k, header[k][0])
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := post(url.Values{"csrf": {token}}, nil, cookies[0]); code != http.StatusOK {
+		t.Errorf("expected %v with the token got %v", http.StatusOK, code)
+	}
+	if code := post(url.Values{}, http.Header{csrfHeader: {token}}, cookies[0]); code != http.StatusOK {
+		t.Errorf("expected %v with the token in a header got %v", http.StatusOK, code)
+	}
+	if code := post(url.Values{}, nil, cookies[0]); code != http.StatusForbidden {
+		t.Errorf("expected %v without a token got %v", http.StatusForbidden, code)
+	}
+	if code := post(url.Values{"csrf": {token}}, nil); code != http.StatusForbidden {
+		t.Errorf("expected %v without the csrf cookie got %v", http.StatusForbidden, code)
+	}
+	if code := post(url.Values{"csrf": {token}}, nil, cookies[0], session); code != http.StatusForbidden {
+		t.Errorf("expected %v with a token from another session got %v", http.StatusForbidden, code)
+	}
+
+	user, _ := registerUser(r, "JaneDoe", "", "correct horse")
+	apiToken, _ := createAPIToken(r, user, "ci")
+	if code := post(url.Values{}, http.Header{"Authorization": {"Bearer " + apiToken}}); code != http.StatusOK {
+		t.Errorf("expected %v with an API token got %v", http.StatusOK, code)
+	}
+}
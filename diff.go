@@ -0,0 +1,284 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+type DiffOp int
+
+const (
+	DiffEqual DiffOp = iota
+	DiffInsert
+	DiffDelete
+)
+
+// Returns the name of the operation, used as a css class when rendering.
+func (op DiffOp) Class() string {
+	switch op {
+	case DiffInsert:
+		return "insert"
+	case DiffDelete:
+		return "delete"
+	}
+	return "equal"
+}
+
+// A run of text within a line, with the operation that produced it.
+type DiffSegment struct {
+	Op   DiffOp
+	Text string
+}
+
+// A single line of a unified diff. OldNumber and NewNumber are the 1 based
+// line numbers in the old and new text, 0 when the line is not present.
+type DiffLine struct {
+	Op        DiffOp
+	OldNumber int
+	NewNumber int
+	Segments  []*DiffSegment
+}
+
+// A row of a side-by-side diff, Left or Right is nil when the line only
+// exists on the other side.
+type DiffRow struct {
+	Left  *DiffLine
+	Right *DiffLine
+}
+
+type Diff struct {
+	Lines []*DiffLine
+	Rows  []*DiffRow
+}
+
+type diffEdit struct {
+	Op   DiffOp
+	Text string
+}
+
+// Computes the line diff between old and new, highlighting the words that
+// changed within modified lines.
+func diffText(old string, new string) *Diff {
+	edits := diffTokens(splitLines(old), splitLines(new))
+	lines := []*DiffLine{}
+	oldNumber, newNumber := 0, 0
+	for i := 0; i < len(edits); {
+		if edits[i].Op == DiffEqual {
+			oldNumber++
+			newNumber++
+			lines = append(lines, &DiffLine{Op: DiffEqual, OldNumber: oldNumber, NewNumber: newNumber,
+				Segments: []*DiffSegment{{Op: DiffEqual, Text: edits[i].Text}}})
+			i++
+			continue
+		}
+
+		deleted, inserted := []string{}, []string{}
+		for ; i < len(edits) && edits[i].Op != DiffEqual; i++ {
+			if edits[i].Op == DiffDelete {
+				deleted = append(deleted, edits[i].Text)
+			} else {
+				inserted = append(inserted, edits[i].Text)
+			}
+		}
+
+		deletedLines := make([]*DiffLine, len(deleted))
+		for n, text := range deleted {
+			oldNumber++
+			deletedLines[n] = &DiffLine{Op: DiffDelete, OldNumber: oldNumber,
+				Segments: []*DiffSegment{{Op: DiffDelete, Text: text}}}
+		}
+		insertedLines := make([]*DiffLine, len(inserted))
+		for n, text := range inserted {
+			newNumber++
+			insertedLines[n] = &DiffLine{Op: DiffInsert, NewNumber: newNumber,
+				Segments: []*DiffSegment{{Op: DiffInsert, Text: text}}}
+		}
+
+		// pair up modified lines and highlight the words that changed
+		for n := 0; n < len(deleted) && n < len(inserted); n++ {
+			deletedLines[n].Segments, insertedLines[n].Segments = diffWords(deleted[n], inserted[n])
+		}
+
+		lines = append(lines, deletedLines...)
+		lines = append(lines, insertedLines...)
+	}
+	return &Diff{Lines: lines, Rows: sideBySide(lines)}
+}
+
+// Arranges the lines of a unified diff into side-by-side rows, pairing
+// deleted lines with the inserted lines that replaced them.
+func sideBySide(lines []*DiffLine) []*DiffRow {
+	rows := []*DiffRow{}
+	for i := 0; i < len(lines); {
+		if lines[i].Op == DiffEqual {
+			rows = append(rows, &DiffRow{Left: lines[i], Right: lines[i]})
+			i++
+			continue
+		}
+		deleted, inserted := []*DiffLine{}, []*DiffLine{}
+		for ; i < len(lines) && lines[i].Op != DiffEqual; i++ {
+			if lines[i].Op == DiffDelete {
+				deleted = append(deleted, lines[i])
+			} else {
+				inserted = append(inserted, lines[i])
+			}
+		}
+		for n := 0; n < len(deleted) || n < len(inserted); n++ {
+			row := &DiffRow{}
+			if n < len(deleted) {
+				row.Left = deleted[n]
+			}
+			if n < len(inserted) {
+				row.Right = inserted[n]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+var wordMatcher = regexp.MustCompile(`\s+|[\p{L}\p{N}_]+|.`)
+
+// Computes the word level diff of a modified line, returning the segments
+// for the old and new versions of the line.
+func diffWords(old string, new string) ([]*DiffSegment, []*DiffSegment) {
+	edits := diffTokens(wordMatcher.FindAllString(old, -1), wordMatcher.FindAllString(new, -1))
+	oldSegments, newSegments := []*DiffSegment{}, []*DiffSegment{}
+	for _, edit := range edits {
+		if edit.Op != DiffInsert {
+			oldSegments = appendSegment(oldSegments, edit)
+		}
+		if edit.Op != DiffDelete {
+			newSegments = appendSegment(newSegments, edit)
+		}
+	}
+	return oldSegments, newSegments
+}
+
+func appendSegment(segments []*DiffSegment, edit diffEdit) []*DiffSegment {
+	if len(segments) > 0 && segments[len(segments)-1].Op == edit.Op {
+		segments[len(segments)-1].Text += edit.Text
+		return segments
+	}
+	return append(segments, &DiffSegment{Op: edit.Op, Text: edit.Text})
+}
+
+func splitLines(text string) []string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Diffs needing more than this many inserted and deleted tokens are shown
+// as all of one replaced by all of the other, bounding the work done for
+// large pages that changed completely.
+const maxDiffEdits = 1000
+
+// Computes the edits turning a into b.
+func diffTokens(a []string, b []string) []diffEdit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := []diffEdit{}
+	for _, token := range a[:prefix] {
+		edits = append(edits, diffEdit{Op: DiffEqual, Text: token})
+	}
+
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	middle := shortestEdits(ma, mb)
+	if middle == nil {
+		for _, token := range ma {
+			middle = append(middle, diffEdit{Op: DiffDelete, Text: token})
+		}
+		for _, token := range mb {
+			middle = append(middle, diffEdit{Op: DiffInsert, Text: token})
+		}
+	}
+	edits = append(edits, middle...)
+
+	for _, token := range a[len(a)-suffix:] {
+		edits = append(edits, diffEdit{Op: DiffEqual, Text: token})
+	}
+	return edits
+}
+
+// Finds the shortest edits turning a into b with Myers' O(ND) algorithm,
+// returning nil if they are more than maxDiffEdits.
+func shortestEdits(a []string, b []string) []diffEdit {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit > maxDiffEdits {
+		limit = maxDiffEdits
+	}
+	// v holds the furthest x reached on each diagonal k = x - y, and trace
+	// the diagonals -d to d of v before each step d, to find the path back.
+	offset := limit + 1
+	v := make([]int, 2*offset+1)
+	trace := [][]int{}
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int{}, v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			x := 0
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackEdits(a, b, trace)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrackEdits(a []string, b []string, trace [][]int) []diffEdit {
+	reversed := []diffEdit{}
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		previous := k - 1
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			previous = k + 1
+		}
+		px := v[d+previous]
+		py := px - previous
+		for x > px && y > py {
+			reversed = append(reversed, diffEdit{Op: DiffEqual, Text: a[x-1]})
+			x--
+			y--
+		}
+		if x == px {
+			reversed = append(reversed, diffEdit{Op: DiffInsert, Text: b[py]})
+		} else {
+			reversed = append(reversed, diffEdit{Op: DiffDelete, Text: a[px]})
+		}
+		x, y = px, py
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, diffEdit{Op: DiffEqual, Text: a[x-1]})
+		x--
+		y--
+	}
+
+	edits := make([]diffEdit, len(reversed))
+	for i, edit := range reversed {
+		edits[len(reversed)-1-i] = edit
+	}
+	return edits
+}
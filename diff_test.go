@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiffTextLines(t *testing.T) {
+	diff := diffText("one\ntwo\nthree\n", "one\nthree\nfour\n")
+	expected := []struct {
+		op        DiffOp
+		oldNumber int
+		newNumber int
+	}{
+		{DiffEqual, 1, 1},
+		{DiffDelete, 2, 0},
+		{DiffEqual, 3, 2},
+		{DiffInsert, 0, 3},
+	}
+	if len(diff.Lines) != len(expected) {
+		t.Fatalf("expected %d lines got %d", len(expected), len(diff.Lines))
+	}
+	for i, e := range expected {
+		line := diff.Lines[i]
+		if line.Op != e.op || line.OldNumber != e.oldNumber || line.NewNumber != e.newNumber {
+			t.Errorf("line %d: expected %v %d/%d got %v %d/%d", i, e.op, e.oldNumber, e.newNumber,
+				line.Op, line.OldNumber, line.NewNumber)
+		}
+	}
+}
+
+func TestDiffTextWords(t *testing.T) {
+	diff := diffText("the quick fox", "the slow fox")
+	if len(diff.Rows) != 1 {
+		t.Fatalf("expected 1 row got %d", len(diff.Rows))
+	}
+	validateSegments(t, diff.Rows[0].Left.Segments, "the |-quick| fox")
+	validateSegments(t, diff.Rows[0].Right.Segments, "the |+slow| fox")
+}
+
+func TestDiffTokensLarge(t *testing.T) {
+	a, b := []string{}, []string{}
+	for i := 0; i < 20000; i++ {
+		a = append(a, fmt.Sprintf("old %d", i))
+		b = append(b, fmt.Sprintf("new %d", i))
+	}
+	edits := diffTokens(a, b)
+	if len(edits) != 40000 || edits[0].Op != DiffDelete || edits[39999].Op != DiffInsert {
+		t.Errorf("expected all lines deleted then inserted got %d edits", len(edits))
+	}
+
+	b = append([]string{}, a...)
+	b[5000], b[15000] = "changed", "changed"
+	edits = diffTokens(a, b)
+	changed := 0
+	for _, edit := range edits {
+		if edit.Op != DiffEqual {
+			changed++
+		}
+	}
+	if len(edits) != 20002 || changed != 4 {
+		t.Errorf("expected 4 changes in 20002 edits got %d in %d", changed, len(edits))
+	}
+}
+
+func validateSegments(t *testing.T, segments []*DiffSegment, expected string) {
+	output := ""
+	for i, segment := range segments {
+		if i > 0 {
+			output += "|"
+		}
+		switch segment.Op {
+		case DiffInsert:
+			output += "+"
+		case DiffDelete:
+			output += "-"
+		}
+		output += segment.Text
+	}
+	if output != expected {
+		t.Errorf("expected '%s' got '%s'", expected, output)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/xml"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+const feedEntries = 20
+
+// The lines of a diff included in a feed entry.
+const feedDiffLines = 20
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string       `xml:"title"`
+	Id      string       `xml:"id"`
+	Updated string       `xml:"updated"`
+	Link    atomLink     `xml:"link"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Link    atomLink    `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link"`
+	Description string     `xml:"description"`
+	Items       []*rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Author      string  `xml:"author,omitempty"`
+	Description string  `xml:"description"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// An entry in a feed of changes, with the html describing the change.
+type feedEntry struct {
+	Change  *Change
+	Link    string
+	Id      string
+	Content string
+}
+
+func feedTitle(web string) string {
+	if web == "" {
+		return "Wiki Changes"
+	}
+	return web + " Changes"
+}
+
+// Creates the feed entries for changes, describing each with the commit
+// message and the diff of the change.
+func feedEntriesForChanges(wikiRepository WikiRepository, baseURL string, changes []*Change) []*feedEntry {
+	entries := []*feedEntry{}
+	for _, change := range changes {
+		view := baseURL + generatePath("view", change.Web, change.Title)
+		content := "<p>" + template.HTMLEscapeString(change.Revision.Message) + "</p>"
+		if !change.Deleted {
+			content += changeDiffSnippet(wikiRepository, change)
+		}
+		entries = append(entries, &feedEntry{
+			Change:  change,
+			Link:    view,
+			Id:      view + "?rev=" + change.Revision.Id,
+			Content: content,
+		})
+	}
+	return entries
+}
+
+// Renders the lines changed by change as html, the whole page when it was
+// created by the change.
+func changeDiffSnippet(wikiRepository WikiRepository, change *Change) string {
+	p, _, err := wikiRepository.ReadPageRevision(change.Web, change.Title, change.Revision.Id)
+	if err != nil {
+		return ""
+	}
+	to, err := publicSource(p)
+	if err != nil {
+		return ""
+	}
+	from := []byte{}
+	if change.Revision.PreviousId != "" {
+		previous, _, err := wikiRepository.ReadPageRevision(change.Web, change.Title, change.Revision.PreviousId)
+		if err == nil {
+			from, _ = publicSource(previous)
+		}
+	}
+	return diffSnippet(diffText(string(from), string(to)), feedDiffLines)
+}
+
+// Renders up to maxLines of the inserted and deleted lines of diff as html.
+func diffSnippet(diff *Diff, maxLines int) string {
+	snippet := new(strings.Builder)
+	lines := 0
+	for _, line := range diff.Lines {
+		if line.Op == DiffEqual {
+			continue
+		}
+		if lines == maxLines {
+			snippet.WriteString("…\n")
+			break
+		}
+		lines++
+		if line.Op == DiffInsert {
+			snippet.WriteString("<ins>+")
+		} else {
+			snippet.WriteString("<del>-")
+		}
+		for _, segment := range line.Segments {
+			snippet.WriteString(template.HTMLEscapeString(segment.Text))
+		}
+		if line.Op == DiffInsert {
+			snippet.WriteString("</ins>\n")
+		} else {
+			snippet.WriteString("</del>\n")
+		}
+	}
+	if lines == 0 {
+		return ""
+	}
+	return "<pre>" + snippet.String() + "</pre>"
+}
+
+func writeAtomFeed(w io.Writer, web string, baseURL string, entries []*feedEntry) error {
+	feed := &atomFeed{
+		Title:   feedTitle(web),
+		Id:      baseURL + "/changes/" + web,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Link:    atomLink{Href: baseURL + "/changes/" + web},
+	}
+	if len(entries) > 0 {
+		feed.Updated = entries[0].Change.Revision.When.UTC().Format(time.RFC3339)
+	}
+	for _, entry := range entries {
+		revision := entry.Change.Revision
+		feed.Entries = append(feed.Entries, &atomEntry{
+			Title:   entry.Change.Web + "." + entry.Change.Title,
+			Id:      entry.Id,
+			Updated: revision.When.UTC().Format(time.RFC3339),
+			Author:  atomAuthor{Name: revision.Author, Email: revision.Email},
+			Link:    atomLink{Href: entry.Link},
+			Content: atomContent{Type: "html", Body: entry.Content},
+		})
+	}
+	return writeFeed(w, feed)
+}
+
+func writeRSSFeed(w io.Writer, web string, baseURL string, entries []*feedEntry) error {
+	feed := &rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       feedTitle(web),
+			Link:        baseURL + "/changes/" + web,
+			Description: "Recent changes to " + strings.ToLower(feedTitle(web)),
+		},
+	}
+	for _, entry := range entries {
+		revision := entry.Change.Revision
+		author := ""
+		if revision.Email != "" {
+			author = revision.Email + " (" + revision.Author + ")"
+		}
+		feed.Channel.Items = append(feed.Channel.Items, &rssItem{
+			Title:       entry.Change.Web + "." + entry.Change.Title,
+			Link:        entry.Link,
+			Guid:        rssGuid{Value: entry.Id},
+			PubDate:     revision.When.Format(time.RFC1123Z),
+			Author:      author,
+			Description: entry.Content,
+		})
+	}
+	return writeFeed(w, feed)
+}
+
+func writeFeed(w io.Writer, feed interface{}) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(feed)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffSnippet(t *testing.T) {
+	snippet := diffSnippet(diffText("one\ntwo\nthree\n", "one\n2 < 3\nthree\n"), 20)
+	expected := "<pre><del>-two</del>\n<ins>+2 &lt; 3</ins>\n</pre>"
+	if snippet != expected {
+		t.Errorf("expected '%s' got '%s'", expected, snippet)
+	}
+}
+
+func TestDiffSnippetLimit(t *testing.T) {
+	snippet := diffSnippet(diffText("", "a\nb\nc\n"), 2)
+	if strings.Count(snippet, "<ins>") != 2 || !strings.Contains(snippet, "…") {
+		t.Errorf("expected two inserted lines and an ellipsis in '%s'", snippet)
+	}
+}
+
+func TestDiffSnippetUnchanged(t *testing.T) {
+	snippet := diffSnippet(diffText("same\n", "same\n"), 20)
+	if snippet != "" {
+		t.Errorf("expected '' got '%s'", snippet)
+	}
+}
@@ -1,17 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
+	log "github.com/Sirupsen/logrus"
 	"gopkg.in/libgit2/git2go.v25"
+	"io"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 type FileWikiRepository struct {
 	Root        string
 	Repo        *git.Repository
 	PushOptions *git.PushOptions
+	Committer   *Author
+	Links       *LinkIndex
+	SearchIndex *SearchIndex
+	lock        sync.Mutex
 }
 
 func NewFileWikiRepository(path string, cloneFromGitRepo string, initFromGitRepo string, originGitRepo string) (*FileWikiRepository, error) {
@@ -54,7 +66,38 @@ func NewFileWikiRepository(path string, cloneFromGitRepo string, initFromGitRepo
 	startGitWorker()
 
 	_, pushOptions := configureOrigin(repo)
-	return &FileWikiRepository{Root: path, Repo: repo, PushOptions: pushOptions}, nil
+	r := &FileWikiRepository{Root: path, Repo: repo, PushOptions: pushOptions,
+		Links: NewLinkIndex(), SearchIndex: NewSearchIndex()}
+	for web := range r.LoadWebs() {
+		r.indexWeb(web)
+	}
+	return r, nil
+}
+
+// Adds every page in web to the link and search indexes.
+func (r *FileWikiRepository) indexWeb(web string) {
+	files, err := ioutil.ReadDir(r.Root + "/" + web)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".md") {
+			continue
+		}
+		p, err := r.ReadPage(web, strings.TrimSuffix(f.Name(), ".md"))
+		if err != nil {
+			log.Warn("Unable to index " + web + "/" + f.Name() + ": " + err.Error())
+			continue
+		}
+		r.indexPage(web, p)
+	}
+}
+
+func (r *FileWikiRepository) indexPage(web string, p *Page) {
+	ref := PageReference{Web: web, Title: p.Title}
+	r.Links.Update(ref, p.Body)
+	r.SearchIndex.Update(ref, p.Body)
 }
 
 func pageToFilename(root string, web string, title string) string {
@@ -65,34 +108,522 @@ func relativePathToPage(web string, title string) string {
 	return web + "/" + title + ".md"
 }
 
+// Deleted pages are kept in the trash directory, by web, so they can be restored.
+const trashDirectory = "_Trash"
+
+func relativePathToTrash(web string) string {
+	return trashDirectory + "/" + web
+}
+
+func relativePathToAttachments(web string, title string) string {
+	return web + "/_attachments/" + title
+}
+
+func relativePathToAttachment(web string, title string, name string) string {
+	return relativePathToAttachments(web, title) + "/" + name
+}
+
+// Identifies the content of a page, calculated as the git blob id so it
+// matches the blob committed for it.
+func contentRevision(body []byte) string {
+	hash := sha1.New()
+	hash.Write([]byte("blob " + strconv.Itoa(len(body)) + "\x00"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 func (r *FileWikiRepository) ReadPage(web string, title string) (*Page, error) {
 	filename := pageToFilename(r.Root, web, title)
-	body, err := ioutil.ReadFile(filename)
+	source, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	return &Page{Title: title, Body: body}, nil
+	return parsePage(title, source)
 }
 
-func (r *FileWikiRepository) WritePage(web string, p *Page) error {
+// Writes the page if it is unchanged since p.Revision was read. Otherwise the
+// concurrent changes are merged, returning an EditConflictError if they overlap.
+func (r *FileWikiRepository) WritePage(web string, p *Page, edit *Edit) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	current, err := ioutil.ReadFile(pageToFilename(r.Root, web, p.Title))
+	if err == nil && contentRevision(current) != p.Revision {
+		merged, ok := r.mergeConcurrentEdit(current, p)
+		if !ok {
+			currentPage, err := parsePage(p.Title, current)
+			if err != nil {
+				return err
+			}
+			return &EditConflictError{Current: currentPage}
+		}
+		p.Meta, p.Body = merged.Meta, merged.Body
+	}
+
+	var author *Author
+	if edit != nil {
+		author = edit.Author
+	}
+	return r.writePage(web, p, author, editMessage(web, p, edit))
+}
+
+// Returns the commit message for an edit, its summary if one was given.
+func editMessage(web string, p *Page, edit *Edit) string {
+	if edit != nil {
+		summary := strings.TrimSpace(edit.Summary)
+		if summary != "" {
+			return summary
+		}
+	}
+	return relativePathToPage(web, p.Title) + " updated"
+}
+
+func (r *FileWikiRepository) mergeConcurrentEdit(current []byte, p *Page) (*Page, bool) {
+	if r.Repo == nil || p.Revision == "" {
+		return nil, false
+	}
+	base, err := readBlob(r.Repo, p.Revision)
+	if err != nil {
+		return nil, false
+	}
+	source, err := pageSource(p)
+	if err != nil {
+		return nil, false
+	}
+	merged, ok := mergeText(string(base), string(current), string(source))
+	if !ok {
+		return nil, false
+	}
+	mergedPage, err := parsePage(p.Title, []byte(merged))
+	return mergedPage, err == nil
+}
+
+func (r *FileWikiRepository) writePage(web string, p *Page, author *Author, message string) error {
+	err := r.writePageSource(web, p)
+	if err != nil {
+		return err
+	}
+
+	GitWorkQueue <- GitWork{Author: author, Action: func(author *Author) {
+		commitPage(r, author, relativePathToPage(web, p.Title), message)
+	}}
+
+	return nil
+}
+
+// Writes the page to its file and indexes it, without committing it.
+func (r *FileWikiRepository) writePageSource(web string, p *Page) error {
+	source, err := pageSource(p)
+	if err != nil {
+		return err
+	}
 	filename := pageToFilename(r.Root, web, p.Title)
-	err := ioutil.WriteFile(filename, p.Body, 0644)
+	err = ioutil.WriteFile(filename, source, 0644)
 	if err != nil {
 		return err
 	}
+	p.Revision = contentRevision(source)
+	r.indexPage(web, p)
+	return nil
+}
 
-	GitWorkQueue <- GitWork{Action: func() {commitPage(r, relativePathToPage(web, p.Title))}}
+func (r *FileWikiRepository) RevertPage(web string, title string, rev string, author *Author) (*Page, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	p, revision, err := r.ReadPageRevision(web, title, rev)
+	if err != nil {
+		return nil, err
+	}
+	// credentials, such as a changed password, are not reverted
+	keepProtectedMeta(r, web, p)
+	message := "Reverted " + web + "/" + title + " to " + shortRevisionId(revision.Id)
+	err = r.writePage(web, p, author, message)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (r *FileWikiRepository) ReadPageRevision(web string, title string, rev string) (*Page, *Revision, error) {
+	if r.Repo == nil {
+		return nil, nil, errors.New("Data directory is not a git repository, no revisions available.")
+	}
+	source, revision, err := readPathAtRevision(r.Repo, relativePathToPage(web, title), rev)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := parsePage(title, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, revision, nil
+}
+
+func (r *FileWikiRepository) PageHistory(web string, title string) ([]*Revision, error) {
+	if r.Repo == nil {
+		return nil, errors.New("Data directory is not a git repository, no history available.")
+	}
+	return pathHistory(r.Repo, relativePathToPage(web, title))
+}
+
+func (r *FileWikiRepository) Backlinks(web string, title string) []PageReference {
+	return r.Links.Backlinks(PageReference{Web: web, Title: title})
+}
+
+func (r *FileWikiRepository) Search(query string, web string) []*SearchResult {
+	return r.SearchIndex.Search(query, web)
+}
+
+func (r *FileWikiRepository) WriteAttachment(web string, title string, name string, content io.Reader, author *Author) error {
+	err := os.MkdirAll(r.Root+"/"+relativePathToAttachments(web, title), 0755)
+	if err != nil {
+		return err
+	}
+	path := relativePathToAttachment(web, title, name)
+	f, err := os.Create(r.Root + "/" + path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	message := "Attached " + name + " to " + web + "/" + title
+	GitWorkQueue <- GitWork{Author: author, Action: func(author *Author) {
+		commitPage(r, author, path, message)
+	}}
 
 	return nil
 }
 
-func (r *FileWikiRepository) CreateWeb(web string) (*Web, error) {
+func (r *FileWikiRepository) ReadAttachment(web string, title string, name string) ([]byte, *Attachment, error) {
+	filename := r.Root + "/" + relativePathToAttachment(web, title, name)
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, &Attachment{Name: name, Size: info.Size(), Modified: info.ModTime()}, nil
+}
+
+func (r *FileWikiRepository) ListAttachments(web string, title string) ([]*Attachment, error) {
+	files, err := ioutil.ReadDir(r.Root + "/" + relativePathToAttachments(web, title))
+	if os.IsNotExist(err) {
+		return []*Attachment{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	attachments := []*Attachment{}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		attachments = append(attachments, &Attachment{Name: f.Name(), Size: f.Size(), Modified: f.ModTime()})
+	}
+	return attachments, nil
+}
+
+// Moves the page and its attachments to toWeb/toTitle, leaving a redirect at
+// the old name and changing links to it in the pages rewrite returns true for.
+func (r *FileWikiRepository) MovePage(web string, title string, toWeb string, toTitle string, rewrite func(PageReference) bool, author *Author) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	from := PageReference{Web: web, Title: title}
+	to := PageReference{Web: toWeb, Title: toTitle}
+	if from == to {
+		return errors.New("Page '" + from.String() + "' can not be moved to itself.")
+	}
+	if _, err := os.Stat(r.Root + "/" + toWeb); err != nil {
+		return errors.New("Web '" + toWeb + "' does not exist.")
+	}
+	if _, err := os.Stat(pageToFilename(r.Root, toWeb, toTitle)); err == nil {
+		return errors.New("Page '" + to.String() + "' already exists.")
+	}
+	p, err := r.ReadPage(web, title)
+	if err != nil {
+		return err
+	}
+	backlinks := r.Links.Backlinks(from)
+
+	err = os.Rename(pageToFilename(r.Root, web, title), pageToFilename(r.Root, toWeb, toTitle))
+	if err != nil {
+		return err
+	}
+	added := []string{relativePathToPage(toWeb, toTitle)}
+	removed := []string{}
+
+	attachments := r.Root + "/" + relativePathToAttachments(web, title)
+	if _, err := os.Stat(attachments); err == nil {
+		err = os.MkdirAll(r.Root+"/"+toWeb+"/_attachments", 0755)
+		if err == nil {
+			err = os.Rename(attachments, r.Root+"/"+relativePathToAttachments(toWeb, toTitle))
+		}
+		if err != nil {
+			return err
+		}
+		added = append(added, relativePathToAttachments(toWeb, toTitle))
+		removed = append(removed, relativePathToAttachments(web, title))
+	}
+
+	stub := &Page{
+		Title: title,
+		Meta:  map[string]interface{}{"redirect": to.String()},
+		Body:  []byte("This page has moved to " + to.String() + ".\n"),
+	}
+	err = r.writePageSource(web, stub)
+	if err != nil {
+		return err
+	}
+	added = append(added, relativePathToPage(web, title))
+
+	p.Title = toTitle
+	r.indexPage(toWeb, p)
+
+	message := "Moved " + relativePathToPage(web, title) + " to " + relativePathToPage(toWeb, toTitle)
+	GitWorkQueue <- GitWork{Author: author, Action: func(author *Author) {
+		commitChanges(r, author, added, removed, message)
+	}}
+
+	if rewrite == nil && web == toWeb {
+		return nil
+	}
+	rewritten := []PageReference{}
+	for _, ref := range backlinks {
+		if rewrite != nil && rewrite(ref) {
+			rewritten = append(rewritten, ref)
+		}
+	}
+	return r.rewriteLinksTo(from, to, rewritten, p, author)
+}
+
+// Rewrites the links in the pages in backlinks, and in the moved page p,
+// from the page from to the page to.
+func (r *FileWikiRepository) rewriteLinksTo(from PageReference, to PageReference, backlinks []PageReference, p *Page, author *Author) error {
+	changed := []string{}
+	for _, ref := range backlinks {
+		linking, err := r.ReadPage(ref.Web, ref.Title)
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		linking.Body = rewriteLinks(linking.Body, ref.Web, from, to)
+		err = r.writePageSource(ref.Web, linking)
+		if err != nil {
+			return err
+		}
+		changed = append(changed, relativePathToPage(ref.Web, ref.Title))
+	}
+
+	var body []byte
+	if from.Web != to.Web {
+		body = qualifyLinks(p.Body, from.Web, from, to)
+	} else {
+		body = rewriteLinks(p.Body, from.Web, from, to)
+	}
+	if !bytes.Equal(body, p.Body) {
+		p.Body = body
+		err := r.writePageSource(to.Web, p)
+		if err != nil {
+			return err
+		}
+		changed = append(changed, relativePathToPage(to.Web, to.Title))
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+	message := "Updated links from " + from.String() + " to " + to.String()
+	GitWorkQueue <- GitWork{Author: author, Action: func(author *Author) {
+		commitChanges(r, author, changed, nil, message)
+	}}
+	return nil
+}
+
+// Moves the page and its attachments to the trash.
+func (r *FileWikiRepository) DeletePage(web string, title string, author *Author) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	trash := relativePathToTrash(web)
+	if info, err := os.Stat(r.Root + "/" + relativePathToPage(trash, title)); err == nil {
+		// keep the copy deleted before, named after its time in the trash
+		earlier := title + info.ModTime().UTC().Format("20060102150405")
+		message := "Kept " + relativePathToPage(trash, title) + " as " + earlier
+		err = r.movePageFiles(trash, title, trash, earlier, author, message)
+		if err != nil {
+			return err
+		}
+	}
+	message := "Deleted " + relativePathToPage(web, title)
+	err := r.movePageFiles(web, title, trash, title, author, message)
+	if err != nil {
+		return err
+	}
+	ref := PageReference{Web: web, Title: title}
+	r.Links.Remove(ref)
+	r.SearchIndex.Remove(ref)
+	return nil
+}
+
+// Moves the page and its attachments from the trash back to its web.
+func (r *FileWikiRepository) RestorePage(web string, title string, author *Author) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, err := os.Stat(r.Root + "/" + web); err != nil {
+		return errors.New("Web '" + web + "' does not exist, unable to restore " + web + "." + title + ".")
+	}
+	message := "Restored " + relativePathToPage(web, title)
+	err := r.movePageFiles(relativePathToTrash(web), title, web, title, author, message)
+	if err != nil {
+		return err
+	}
+	p, err := r.ReadPage(web, title)
+	if err != nil {
+		return err
+	}
+	r.indexPage(web, p)
+	return nil
+}
+
+// Moves the page file and attachments from the directory web to toWeb as
+// toTitle, committing the move in a single commit.
+func (r *FileWikiRepository) movePageFiles(web string, title string, toWeb string, toTitle string, author *Author, message string) error {
+	page := relativePathToPage(web, title)
+	toPage := relativePathToPage(toWeb, toTitle)
+	if _, err := os.Stat(r.Root + "/" + page); err != nil {
+		return errors.New("Page '" + page + "' does not exist.")
+	}
+	if _, err := os.Stat(r.Root + "/" + toPage); err == nil {
+		return errors.New("Page '" + toPage + "' already exists.")
+	}
+
+	err := os.MkdirAll(r.Root+"/"+toWeb, 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(r.Root+"/"+page, r.Root+"/"+toPage)
+	if err != nil {
+		return err
+	}
+	added := []string{toPage}
+	removed := []string{page}
+
+	attachments := relativePathToAttachments(web, title)
+	toAttachments := relativePathToAttachments(toWeb, toTitle)
+	if _, err := os.Stat(r.Root + "/" + attachments); err == nil {
+		err = os.RemoveAll(r.Root + "/" + toAttachments)
+		if err == nil {
+			err = os.MkdirAll(r.Root+"/"+toWeb+"/_attachments", 0755)
+		}
+		if err == nil {
+			err = os.Rename(r.Root+"/"+attachments, r.Root+"/"+toAttachments)
+		}
+		if err != nil {
+			return err
+		}
+		added = append(added, toAttachments)
+		removed = append(removed, attachments)
+	}
+
+	GitWorkQueue <- GitWork{Author: author, Action: func(author *Author) {
+		commitChanges(r, author, added, removed, message)
+	}}
+	return nil
+}
+
+// Lists the pages in the trash, most recently deleted first.
+func (r *FileWikiRepository) ListTrash() ([]*TrashedPage, error) {
+	webs, err := ioutil.ReadDir(r.Root + "/" + trashDirectory)
+	if os.IsNotExist(err) {
+		return []*TrashedPage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	trash := []*TrashedPage{}
+	for _, web := range webs {
+		if !web.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(r.Root + "/" + relativePathToTrash(web.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), ".md") {
+				continue
+			}
+			trash = append(trash, &TrashedPage{Web: web.Name(), Title: strings.TrimSuffix(f.Name(), ".md"), Deleted: f.ModTime()})
+		}
+	}
+	sort.Slice(trash, func(i, j int) bool {
+		return trash[i].Deleted.After(trash[j].Deleted)
+	})
+	return trash, nil
+}
+
+// Lists the pages in web, sorted by title, with the author and time of their
+// last change taken from git when the data directory is a repository.
+func (r *FileWikiRepository) ListPages(web string) ([]*PageInfo, error) {
+	files, err := ioutil.ReadDir(r.Root + "/" + web)
+	if err != nil {
+		return nil, err
+	}
+	pages := []*PageInfo{}
+	paths := []string{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".md") {
+			continue
+		}
+		title := strings.TrimSuffix(f.Name(), ".md")
+		pages = append(pages, &PageInfo{Web: web, Title: title, Size: f.Size(), Modified: f.ModTime()})
+		paths = append(paths, relativePathToPage(web, title))
+	}
+
+	if r.Repo != nil {
+		revisions, err := lastRevisions(r.Repo, paths)
+		if err != nil {
+			log.Warn(err)
+		}
+		for i, p := range pages {
+			if revision, ok := revisions[paths[i]]; ok {
+				p.Author = revision.Author
+				p.Modified = revision.When
+			}
+		}
+	}
+	return pages, nil
+}
+
+func (r *FileWikiRepository) RecentChanges(web string, offset int, limit int) ([]*Change, error) {
+	if r.Repo == nil {
+		return nil, errors.New("Data directory is not a git repository, no changes available.")
+	}
+	return recentChanges(r.Repo, web, offset, limit)
+}
+
+func (r *FileWikiRepository) CreateWeb(web string, author *Author) (*Web, error) {
 	err := CopyDir(r.Root+"/_empty", r.Root+"/"+web)
 	if err != nil {
 		return nil, err
 	}
 
-	GitWorkQueue <- GitWork{Action: func() {commitWeb(r, web)}}
+	r.indexWeb(web)
+
+	GitWorkQueue <- GitWork{Author: author, Action: func(author *Author) {
+		commitWeb(r, author, web)
+	}}
 
 	//TODO... create web with properties?
 	return &Web{Name:web}, nil
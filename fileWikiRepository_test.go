@@ -3,8 +3,21 @@ package main
 import (
 	"testing"
 	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
+func TestContentRevisionMatchesGitBlobId(t *testing.T) {
+	// git hash-object for "Hello, world!\n"
+	revision := contentRevision([]byte("Hello, world!\n"))
+	if revision != "af5626b4a114abcb82d63db7c8082c3c4756e51b" {
+		t.Errorf("expected '%s' got '%s'", "af5626b4a114abcb82d63db7c8082c3c4756e51b", revision)
+	}
+}
+
 func TestPageToFilename(t *testing.T) {
 	filename := pageToFilename("data", "Main", "WebTest")
 	if filename != "data/Main/WebTest.md" {
@@ -12,16 +25,241 @@ func TestPageToFilename(t *testing.T) {
 	}
 }
 
+func TestEditMessage(t *testing.T) {
+	p := &Page{Title: "WebHome"}
+	messages := []struct {
+		edit     *Edit
+		expected string
+	}{
+		{nil, "Main/WebHome.md updated"},
+		{&Edit{Summary: "  "}, "Main/WebHome.md updated"},
+		{&Edit{Summary: " Fixed typos\n"}, "Fixed typos"},
+	}
+	for _, m := range messages {
+		message := editMessage("Main", p, m.edit)
+		if message != m.expected {
+			t.Errorf("expected '%s' got '%s'", m.expected, message)
+		}
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	root, err := ioutil.TempDir("", "gowiki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	r := &FileWikiRepository{Root: root, Links: NewLinkIndex(), SearchIndex: NewSearchIndex()}
+
+	attachments, err := r.ListAttachments("Main", "WebHome")
+	if err != nil || len(attachments) != 0 {
+		t.Errorf("expected no attachments got %v %v", attachments, err)
+	}
+
+	err = r.WriteAttachment("Main", "WebHome", "notes.txt", strings.NewReader("Some notes"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drainGitWorkQueue()
+
+	content, attachment, err := r.ReadAttachment("Main", "WebHome", "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "Some notes" || attachment.Size != 10 {
+		t.Errorf("expected '%s' got '%s' (%d bytes)", "Some notes", content, attachment.Size)
+	}
+
+	attachments, _ = r.ListAttachments("Main", "WebHome")
+	if len(attachments) != 1 || attachments[0].Name != "notes.txt" {
+		t.Errorf("expected [notes.txt] got %v", attachments)
+	}
+}
+
+func createTestFileWikiRepository(t *testing.T, pages map[string]string) *FileWikiRepository {
+	root, err := ioutil.TempDir("", "gowiki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &FileWikiRepository{Root: root, Links: NewLinkIndex(), SearchIndex: NewSearchIndex()}
+	for path, body := range pages {
+		os.MkdirAll(filepath.Dir(root+"/"+path), 0755)
+		err = ioutil.WriteFile(root+"/"+path, []byte(body), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for web := range r.LoadWebs() {
+		r.indexWeb(web)
+	}
+	return r
+}
+
+// Discards queued commits, tests run without a git repository.
+func drainGitWorkQueue() {
+	for {
+		select {
+		case <-GitWorkQueue:
+		default:
+			return
+		}
+	}
+}
+
+func TestMovePage(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/OldName.md": "Links to WebHome",
+		"Main/WebHome.md": "See OldName",
+		"Design/WebHome.md": "See Main.OldName",
+		"Main/Notes.md": "See WebHome",
+	})
+	defer os.RemoveAll(r.Root)
+
+	rewrite := func(ref PageReference) bool { return ref.Web == "Main" }
+	err := r.MovePage("Main", "OldName", "Design", "NewName", rewrite, nil)
+	drainGitWorkQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validatePageBody(t, r, "Design", "NewName", "Links to Main.WebHome")
+	validatePageBody(t, r, "Main", "WebHome", "See Design.NewName")
+	validatePageBody(t, r, "Design", "WebHome", "See Main.OldName")
+
+	stub, err := r.ReadPage("Main", "OldName")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.Meta["redirect"] != "Design.NewName" {
+		t.Errorf("expected redirect to '%s' got '%v'", "Design.NewName", stub.Meta["redirect"])
+	}
+
+	if err = r.MovePage("Main", "WebHome", "Design", "NewName", nil, nil); err == nil {
+		t.Errorf("expected error moving onto an existing page")
+	}
+
+	// links of the moved page still lead to the pages of its old web
+	err = r.MovePage("Main", "Notes", "Design", "Notes", nil, nil)
+	drainGitWorkQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	validatePageBody(t, r, "Design", "Notes", "See Main.WebHome")
+}
+
+func TestDeleteAndRestorePage(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/WebHome.md":  "See StalePage",
+		"Main/StalePage.md": "Out of date, see WebHome",
+		"Main/_attachments/StalePage/notes.txt": "Notes",
+	})
+	defer os.RemoveAll(r.Root)
+
+	err := r.DeletePage("Main", "StalePage", nil)
+	drainGitWorkQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.ReadPage("Main", "StalePage"); err == nil {
+		t.Errorf("expected deleted page to be gone")
+	}
+	if len(r.Backlinks("Main", "WebHome")) != 0 {
+		t.Errorf("expected deleted page to be removed from the link index")
+	}
+	trash, err := r.ListTrash()
+	if err != nil || len(trash) != 1 || trash[0].Web != "Main" || trash[0].Title != "StalePage" {
+		t.Fatalf("expected Main.StalePage in trash got %v %v", trash, err)
+	}
+
+	err = r.RestorePage("Main", "StalePage", nil)
+	drainGitWorkQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	validatePageBody(t, r, "Main", "StalePage", "Out of date, see WebHome")
+	if content, _, err := r.ReadAttachment("Main", "StalePage", "notes.txt"); err != nil || string(content) != "Notes" {
+		t.Errorf("expected restored attachment got '%s' %v", content, err)
+	}
+	if trash, _ = r.ListTrash(); len(trash) != 0 {
+		t.Errorf("expected empty trash got %v", trash)
+	}
+}
+
+func TestDeletePageAlreadyInTrash(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/StalePage.md":                            "Second",
+		"_Trash/Main/StalePage.md":                     "First",
+		"_Trash/Main/_attachments/StalePage/notes.txt": "Notes",
+	})
+	defer os.RemoveAll(r.Root)
+
+	err := r.DeletePage("Main", "StalePage", nil)
+	drainGitWorkQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	trash, err := r.ListTrash()
+	if err != nil || len(trash) != 2 {
+		t.Fatalf("expected both deleted copies in trash got %v %v", trash, err)
+	}
+	for _, trashed := range trash {
+		p, err := r.ReadPage(relativePathToTrash("Main"), trashed.Title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "First"
+		if trashed.Title == "StalePage" {
+			expected = "Second"
+		} else if _, _, err := r.ReadAttachment(relativePathToTrash("Main"), trashed.Title, "notes.txt"); err != nil {
+			t.Errorf("expected the attachment kept with the first copy got %v", err)
+		}
+		if string(p.Body) != expected {
+			t.Errorf("expected '%s' got '%s'", expected, p.Body)
+		}
+	}
+}
+
+func TestListPages(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/WebHome.md":  "Home",
+		"Main/LoginRequirement.md": "Requirement",
+		"Main/_attachments/LoginRequirement/notes.txt": "Notes",
+	})
+	defer os.RemoveAll(r.Root)
+
+	pages, err := r.ListPages("Main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 || pages[0].Title != "LoginRequirement" || pages[1].Title != "WebHome" || pages[1].Size != 4 {
+		t.Errorf("expected [LoginRequirement WebHome] got %v", pages)
+	}
+}
+
+func validatePageBody(t *testing.T, r *FileWikiRepository, web string, title string, expected string) {
+	p, err := r.ReadPage(web, title)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(p.Body) != expected {
+		t.Errorf("expected '%s' got '%s'", expected, p.Body)
+	}
+}
 
 type FakeWikiRepository struct {
-	readFn func(string, string) (*Page, error)
+	readFn  func(string, string) (*Page, error)
+	writeFn func(string, *Page) error
 }
 
 func NewFakeWikiRepository(fn func(string, string) (*Page, error)) *FakeWikiRepository {
 	return &FakeWikiRepository{readFn: fn}
 }
 
-func (f *FakeWikiRepository) WritePage(web string, p *Page) error {
+func (f *FakeWikiRepository) WritePage(web string, p *Page, edit *Edit) error {
+	if f.writeFn != nil {
+		return f.writeFn(web, p)
+	}
 	return nil
 }
 
@@ -29,10 +267,78 @@ func (f *FakeWikiRepository) ReadPage(web string, title string) (*Page, error) {
 	return f.readFn(web, title)
 }
 
-func (f *FakeWikiRepository) CreateWeb(web string) error {
+func (f *FakeWikiRepository) ReadPageRevision(web string, title string, rev string) (*Page, *Revision, error) {
+	p, err := f.readFn(web, title)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, &Revision{Id: rev, Author: "Guest User"}, nil
+}
+
+func (f *FakeWikiRepository) PageHistory(web string, title string) ([]*Revision, error) {
+	return []*Revision{}, nil
+}
+
+func (f *FakeWikiRepository) RevertPage(web string, title string, rev string, author *Author) (*Page, error) {
+	return f.readFn(web, title)
+}
+
+func (f *FakeWikiRepository) Backlinks(web string, title string) []PageReference {
+	return []PageReference{{Web: "Main", Title: "WebHome"}}
+}
+
+func (f *FakeWikiRepository) Search(query string, web string) []*SearchResult {
+	return []*SearchResult{}
+}
+
+func (f *FakeWikiRepository) WriteAttachment(web string, title string, name string, content io.Reader, author *Author) error {
 	return nil
 }
 
+func (f *FakeWikiRepository) ReadAttachment(web string, title string, name string) ([]byte, *Attachment, error) {
+	return nil, nil, errors.New("file not found")
+}
+
+func (f *FakeWikiRepository) ListAttachments(web string, title string) ([]*Attachment, error) {
+	return []*Attachment{}, nil
+}
+
+func (f *FakeWikiRepository) MovePage(web string, title string, toWeb string, toTitle string, rewriteLinks func(PageReference) bool, author *Author) error {
+	return nil
+}
+
+func (f *FakeWikiRepository) DeletePage(web string, title string, author *Author) error {
+	return nil
+}
+
+func (f *FakeWikiRepository) RestorePage(web string, title string, author *Author) error {
+	return nil
+}
+
+func (f *FakeWikiRepository) ListTrash() ([]*TrashedPage, error) {
+	return []*TrashedPage{}, nil
+}
+
+func (f *FakeWikiRepository) ListPages(web string) ([]*PageInfo, error) {
+	return []*PageInfo{
+		{Web: web, Title: "WebHome", Size: 13},
+		{Web: web, Title: "Changelog", Size: 20},
+	}, nil
+}
+
+func (f *FakeWikiRepository) RecentChanges(web string, offset int, limit int) ([]*Change, error) {
+	changes := []*Change{}
+	for i := offset; i < 60 && len(changes) < limit; i++ {
+		changes = append(changes, &Change{Web: "Main", Title: "WebHome",
+			Revision: &Revision{Id: "abc1234", Author: "Guest User", Message: "Main/WebHome.md updated"}})
+	}
+	return changes, nil
+}
+
+func (f *FakeWikiRepository) CreateWeb(web string, author *Author) (*Web, error) {
+	return &Web{Name: web}, nil
+}
+
 func (f *FakeWikiRepository) LoadWebs() map[string]*Web {
 	return map[string]*Web {
 		"Main":&Web{},
@@ -48,3 +354,10 @@ var fakeWikiRepositoryWithFile = NewFakeWikiRepository(func(web string, title st
 var fakeWikiRepositoryNoFile = NewFakeWikiRepository(func(web string, title string) (*Page, error) {
 	return nil, errors.New("file not found")
 })
+
+var fakeWikiRepositoryWithConflict = &FakeWikiRepository{
+	readFn: fakeWikiRepositoryWithFile.readFn,
+	writeFn: func(web string, p *Page) error {
+		return &EditConflictError{Current: &Page{Title: p.Title, Body: []byte("Changed elsewhere"), Revision: "def5678"}}
+	},
+}
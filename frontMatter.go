@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v2"
+)
+
+// Front matter is a YAML block at the start of a page, between two lines of
+// "---", holding the page metadata.
+const frontMatterDelimiter = "---"
+
+// Splits the source of a page into its front matter metadata and body.
+// Source without front matter is all body.
+func parseFrontMatter(source []byte) (map[string]interface{}, []byte, error) {
+	meta := map[string]interface{}{}
+	source = bytes.Replace(source, []byte("\r\n"), []byte("\n"), -1)
+	if !bytes.HasPrefix(source, []byte(frontMatterDelimiter+"\n")) {
+		return meta, source, nil
+	}
+
+	// search from the opening delimiter's newline so empty front matter is found
+	rest := source[len(frontMatterDelimiter):]
+	closing := []byte("\n" + frontMatterDelimiter)
+	end := bytes.Index(rest, []byte("\n"+frontMatterDelimiter+"\n"))
+	if end < 0 {
+		if !bytes.HasSuffix(rest, closing) {
+			return meta, source, nil
+		}
+		end = len(rest) - len(closing)
+	}
+	rest = rest[1:]
+
+	err := yaml.Unmarshal(rest[:end], &meta)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range meta {
+		meta[k] = normaliseMetaValue(v)
+	}
+
+	body := rest[end:]
+	body = bytes.TrimPrefix(body, []byte(frontMatterDelimiter))
+	body = bytes.TrimPrefix(body, []byte("\n"))
+	return meta, body, nil
+}
+
+// Converts the map[interface{}]interface{} values yaml produces for nested
+// mappings into map[string]interface{}, so they work in templates and json.
+func normaliseMetaValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normaliseMetaValue(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normaliseMetaValue(item)
+		}
+		return v
+	}
+	return value
+}
+
+// Joins the metadata and body of a page back into its source, omitting the
+// front matter when there is no metadata.
+func renderFrontMatter(meta map[string]interface{}, body []byte) ([]byte, error) {
+	if len(meta) == 0 {
+		return body, nil
+	}
+	out, err := yaml.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	source := new(bytes.Buffer)
+	source.WriteString(frontMatterDelimiter)
+	source.WriteString("\n")
+	source.Write(out)
+	source.WriteString(frontMatterDelimiter)
+	source.WriteString("\n")
+	source.Write(body)
+	return source.Bytes(), nil
+}
+
+// Creates a page from its source, including any front matter.
+func parsePage(title string, source []byte) (*Page, error) {
+	meta, body, err := parseFrontMatter(source)
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Title: title, Body: body, Meta: meta, Revision: contentRevision(source)}, nil
+}
+
+// Returns the source of the page as stored, with its front matter.
+func pageSource(p *Page) ([]byte, error) {
+	return renderFrontMatter(p.Meta, p.Body)
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestParseFrontMatter(t *testing.T) {
+	meta, body, err := parseFrontMatter([]byte("---\n_parent: WikiUsers\ntags:\n  - design\n---\nHello, world!\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta["_parent"] != "WikiUsers" {
+		t.Errorf("expected '%s' got '%v'", "WikiUsers", meta["_parent"])
+	}
+	if tags, ok := meta["tags"].([]interface{}); !ok || len(tags) != 1 || tags[0] != "design" {
+		t.Errorf("expected tags [design] got '%v'", meta["tags"])
+	}
+	if string(body) != "Hello, world!\n" {
+		t.Errorf("expected '%s' got '%s'", "Hello, world!\n", body)
+	}
+}
+
+func TestParseWithoutFrontMatter(t *testing.T) {
+	meta, body, err := parseFrontMatter([]byte("---\nnot front matter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta) != 0 {
+		t.Errorf("expected no meta got '%v'", meta)
+	}
+	if string(body) != "---\nnot front matter" {
+		t.Errorf("expected '%s' got '%s'", "---\nnot front matter", body)
+	}
+}
+
+func TestRenderFrontMatter(t *testing.T) {
+	source, err := renderFrontMatter(map[string]interface{}{"_parent": "WikiUsers"}, []byte("Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(source) != "---\n_parent: WikiUsers\n---\nHello" {
+		t.Errorf("expected '%s' got '%s'", "---\n_parent: WikiUsers\n---\nHello", source)
+	}
+
+	source, _ = renderFrontMatter(map[string]interface{}{}, []byte("Hello"))
+	if string(source) != "Hello" {
+		t.Errorf("expected '%s' got '%s'", "Hello", source)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	log "github.com/Sirupsen/logrus"
 )
 
+// A change to commit to the data repository, made by Author.
 type GitWork struct {
-	Action func()
+	Author *Author
+	Action func(author *Author)
 }
 
 var GitWorkQueue = make(chan GitWork, 10)
@@ -16,7 +18,7 @@ func startGitWorker() {
 	go func() {
 		for {
 			work := <-GitWorkQueue
-			work.Action()
+			work.Action(work.Author)
 		}
 	}()
 }
@@ -39,13 +41,24 @@ func pushToOrigin(r *FileWikiRepository) {
 	}
 }
 
-func commitWeb(r *FileWikiRepository, web string) {
+// Returns the signatures for a commit by author, committed by the
+// repository's committer or by author when none is configured.
+func commitSignatures(r *FileWikiRepository, author *Author) (*git.Signature, *git.Signature) {
+	if author == nil {
+		author = guestAuthor
+	}
+	committer := r.Committer
+	if committer == nil {
+		committer = author
+	}
+	when := time.Now()
+	return &git.Signature{Name: author.Name, Email: author.Email, When: when},
+		&git.Signature{Name: committer.Name, Email: committer.Email, When: when}
+}
+
+func commitWeb(r *FileWikiRepository, author *Author, web string) {
 	if r.Repo != nil {
-		sig := &git.Signature{
-			Name:  "Guest User",
-			Email: "guest@example.com",
-			When:  time.Now(),
-		}
+		sig, committerSig := commitSignatures(r, author)
 		idx, err := r.Repo.Index()
 		if err != nil {
 			log.Error(err)
@@ -82,7 +95,7 @@ func commitWeb(r *FileWikiRepository, web string) {
 			return
 		}
 		message := web + " created"
-		commitId, err := r.Repo.CreateCommit("HEAD", sig, sig, message, tree, currentTip)
+		commitId, err := r.Repo.CreateCommit("HEAD", sig, committerSig, message, tree, currentTip)
 		if err != nil {
 			log.Error(err)
 			return
@@ -95,22 +108,33 @@ func commitWeb(r *FileWikiRepository, web string) {
 	return
 }
 
-func commitPage(r *FileWikiRepository, path string) {
+func commitPage(r *FileWikiRepository, author *Author, path string, message string) {
+	commitChanges(r, author, []string{path}, nil, message)
+}
+
+// Commits the files and directories in added and removes those in removed,
+// so a move or delete is recorded in a single commit.
+func commitChanges(r *FileWikiRepository, author *Author, added []string, removed []string, message string) {
 	if r.Repo != nil {
-		sig := &git.Signature{
-			Name:  "Guest User",
-			Email: "guest@example.com",
-			When:  time.Now(),
-		}
+		sig, committerSig := commitSignatures(r, author)
 		idx, err := r.Repo.Index()
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		err = idx.AddByPath(path) //AddAll
-		if err != nil {
-			log.Error(err)
-			return
+		if len(removed) > 0 {
+			err = idx.RemoveAll(removed, nil)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+		}
+		if len(added) > 0 {
+			err = idx.AddAll(added, git.IndexAddDefault, nil)
+			if err != nil {
+				log.Error(err)
+				return
+			}
 		}
 		err = idx.Write()
 		if err != nil {
@@ -137,8 +161,7 @@ func commitPage(r *FileWikiRepository, path string) {
 			log.Error(err)
 			return
 		}
-		message := path + " updated"
-		commitId, err := r.Repo.CreateCommit("HEAD", sig, sig, message, tree, currentTip)
+		commitId, err := r.Repo.CreateCommit("HEAD", sig, committerSig, message, tree, currentTip)
 		if err != nil {
 			log.Error(err)
 			return
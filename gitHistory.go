@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"gopkg.in/libgit2/git2go.v25"
+	"strings"
+)
+
+// Returns the id of the blob at path in the tree of commit, or nil if the
+// path does not exist in that commit.
+func blobIdAtPath(commit *git.Commit, path string) *git.Oid {
+	if commit == nil {
+		return nil
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil
+	}
+	entry, err := tree.EntryByPath(path)
+	if err != nil || entry == nil {
+		return nil
+	}
+	return entry.Id
+}
+
+// Returns true if the file at path differs between commit and its first parent.
+func commitTouchesPath(commit *git.Commit, path string) bool {
+	current := blobIdAtPath(commit, path)
+	var previous *git.Oid
+	if commit.ParentCount() > 0 {
+		previous = blobIdAtPath(commit.Parent(0), path)
+	}
+	if current == nil || previous == nil {
+		return current != previous
+	}
+	return !current.Equal(previous)
+}
+
+// Abbreviates a commit id for display, as git does.
+func shortRevisionId(id string) string {
+	if len(id) > 7 {
+		return id[:7]
+	}
+	return id
+}
+
+func revisionFromCommit(commit *git.Commit) *Revision {
+	author := commit.Author()
+	return &Revision{
+		Id:      commit.Id().String(),
+		Author:  author.Name,
+		Email:   author.Email,
+		When:    author.When,
+		Message: strings.TrimSpace(commit.Message()),
+	}
+}
+
+// Returns the path the file at path was moved from in commit, or "" if it
+// was not moved there. A move is a file added with the same content as a
+// page in the parent commit that the commit removed or replaced, as a move
+// leaves a redirect behind. Copies, such as the pages of a new web copied
+// from _empty, are not moves.
+func renamedFrom(commit *git.Commit, path string) string {
+	if commit.ParentCount() == 0 {
+		return ""
+	}
+	parent := commit.Parent(0)
+	id := blobIdAtPath(commit, path)
+	if id == nil || blobIdAtPath(parent, path) != nil {
+		return ""
+	}
+	tree, err := parent.Tree()
+	if err != nil {
+		return ""
+	}
+	previous := ""
+	tree.Walk(func(root string, entry *git.TreeEntry) int {
+		if entry.Type != git.ObjectBlob || !strings.HasSuffix(entry.Name, ".md") || !entry.Id.Equal(id) {
+			return 0
+		}
+		if current := blobIdAtPath(commit, root+entry.Name); current != nil && current.Equal(id) {
+			return 0
+		}
+		previous = root + entry.Name
+		return -1
+	})
+	return previous
+}
+
+// Walks the git log from HEAD, newest first, calling fn with each commit and
+// the path the file at path had in that commit, following moves. Stops
+// when fn returns false.
+func walkPathHistory(repo *git.Repository, path string, fn func(*git.Commit, string) bool) error {
+	walk, err := repo.Walk()
+	if err != nil {
+		return err
+	}
+	defer walk.Free()
+
+	walk.Sorting(git.SortTime)
+	err = walk.PushHead()
+	if err != nil {
+		return err
+	}
+
+	return walk.Iterate(func(commit *git.Commit) bool {
+		if !fn(commit, path) {
+			return false
+		}
+		if previous := renamedFrom(commit, path); previous != "" {
+			path = previous
+		}
+		return true
+	})
+}
+
+// Returns a revision for each commit that changed the file at path, newest
+// first, following the file back through moves.
+func pathHistory(repo *git.Repository, path string) ([]*Revision, error) {
+	revisions := []*Revision{}
+	err := walkPathHistory(repo, path, func(commit *git.Commit, path string) bool {
+		if commitTouchesPath(commit, path) {
+			revisions = append(revisions, revisionFromCommit(commit))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(revisions)-1; i++ {
+		revisions[i].PreviousId = revisions[i+1].Id
+	}
+	return revisions, nil
+}
+
+// Returns the path the file at path had in commit, before any later moves.
+func pathAtCommit(repo *git.Repository, path string, commit *git.Commit) string {
+	found := ""
+	walkPathHistory(repo, path, func(c *git.Commit, p string) bool {
+		if c.Id().Equal(commit.Id()) {
+			found = p
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// Resolves rev (a commit id, abbreviated id or other revision spec) to a commit.
+func lookupRevision(repo *git.Repository, rev string) (*git.Commit, error) {
+	object, err := repo.RevparseSingle(rev)
+	if err != nil {
+		return nil, err
+	}
+	peeled, err := object.Peel(git.ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	return peeled.AsCommit()
+}
+
+// Reads the contents of the file at path as it was in revision rev.
+func readPathAtRevision(repo *git.Repository, path string, rev string) ([]byte, *Revision, error) {
+	commit, err := lookupRevision(repo, rev)
+	if err != nil {
+		return nil, nil, err
+	}
+	blobId := blobIdAtPath(commit, path)
+	if blobId == nil {
+		blobId = blobIdAtPath(commit, pathAtCommit(repo, path, commit))
+	}
+	if blobId == nil {
+		return nil, nil, errors.New("'" + path + "' does not exist in revision '" + rev + "'.")
+	}
+	blob, err := repo.LookupBlob(blobId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return blob.Contents(), revisionFromCommit(commit), nil
+}
+
+// Reads the contents of the blob with the given id.
+func readBlob(repo *git.Repository, id string) ([]byte, error) {
+	oid, err := git.NewOid(id)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := repo.LookupBlob(oid)
+	if err != nil {
+		return nil, err
+	}
+	return blob.Contents(), nil
+}
+
+// Returns the most recent revision changing each of paths, walking the log
+// once for all of them and only until each is found. Paths without history
+// are missing from the result.
+func lastRevisions(repo *git.Repository, paths []string) (map[string]*Revision, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	tip, err := repo.LookupCommit(head.Target())
+	if err != nil {
+		return nil, err
+	}
+	// paths not committed yet would never be found, walking all the history
+	remaining := map[string]bool{}
+	for _, path := range paths {
+		if blobIdAtPath(tip, path) != nil {
+			remaining[path] = true
+		}
+	}
+	revisions := map[string]*Revision{}
+	if len(remaining) == 0 {
+		return revisions, nil
+	}
+
+	walk, err := repo.Walk()
+	if err != nil {
+		return nil, err
+	}
+	defer walk.Free()
+
+	walk.Sorting(git.SortTime)
+	err = walk.PushHead()
+	if err != nil {
+		return nil, err
+	}
+
+	err = walk.Iterate(func(commit *git.Commit) bool {
+		// paths are only looked up in the directories the commit changed,
+		// compared by the ids of their trees
+		changed := map[string]bool{}
+		for path := range remaining {
+			dir := path[:strings.LastIndex(path, "/")+1]
+			touched, ok := changed[dir]
+			if !ok {
+				touched = dir == "" || commitTouchesPath(commit, strings.TrimSuffix(dir, "/"))
+				changed[dir] = touched
+			}
+			if touched && commitTouchesPath(commit, path) {
+				revisions[path] = revisionFromCommit(commit)
+				delete(remaining, path)
+			}
+		}
+		return len(remaining) > 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// Returns the page a path in the data repository is for, if it is a page.
+func pageReferenceFromPath(path string) (PageReference, bool) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 || !strings.HasSuffix(parts[1], ".md") ||
+		strings.HasPrefix(parts[0], "_") || strings.HasPrefix(parts[0], ".") {
+		return PageReference{}, false
+	}
+	return PageReference{Web: parts[0], Title: strings.TrimSuffix(parts[1], ".md")}, true
+}
+
+// Returns the changes to pages made by commit, compared to its first parent.
+func pageChangesInCommit(repo *git.Repository, commit *git.Commit) ([]*Change, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var parentTree *git.Tree
+	var parent *git.Commit
+	if commit.ParentCount() > 0 {
+		parent = commit.Parent(0)
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	diff, err := repo.DiffTreeToTree(parentTree, tree, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer diff.Free()
+	deltas, err := diff.NumDeltas()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []*Change{}
+	for i := 0; i < deltas; i++ {
+		delta, err := diff.GetDelta(i)
+		if err != nil {
+			return nil, err
+		}
+		path := delta.NewFile.Path
+		if delta.Status == git.DeltaDeleted {
+			path = delta.OldFile.Path
+		}
+		ref, ok := pageReferenceFromPath(path)
+		if !ok {
+			continue
+		}
+		revision := revisionFromCommit(commit)
+		if parent != nil && delta.Status != git.DeltaAdded {
+			revision.PreviousId = parent.Id().String()
+		}
+		changes = append(changes, &Change{Web: ref.Web, Title: ref.Title, Revision: revision,
+			Deleted: delta.Status == git.DeltaDeleted})
+	}
+	return changes, nil
+}
+
+// Returns the changes to pages in web, or in every web when web is empty,
+// newest first, skipping the first offset changes and returning at most limit.
+func recentChanges(repo *git.Repository, web string, offset int, limit int) ([]*Change, error) {
+	walk, err := repo.Walk()
+	if err != nil {
+		return nil, err
+	}
+	defer walk.Free()
+
+	walk.Sorting(git.SortTime)
+	err = walk.PushHead()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []*Change{}
+	var walkErr error
+	err = walk.Iterate(func(commit *git.Commit) bool {
+		pageChanges, err := pageChangesInCommit(repo, commit)
+		if err != nil {
+			walkErr = err
+			return false
+		}
+		for _, change := range pageChanges {
+			if web != "" && change.Web != web {
+				continue
+			}
+			if offset > 0 {
+				offset--
+				continue
+			}
+			changes = append(changes, change)
+		}
+		return len(changes) < limit
+	})
+	if err == nil {
+		err = walkErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
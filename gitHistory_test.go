@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestPageReferenceFromPath(t *testing.T) {
+	validatePageReferenceFromPath(t, "Main/WebHome.md", "Main.WebHome", true)
+	validatePageReferenceFromPath(t, "_Trash/Main/WebHome.md", "", false)
+	validatePageReferenceFromPath(t, "_empty/WebHome.md", "", false)
+	validatePageReferenceFromPath(t, "Main/_attachments/WebHome/notes.md", "", false)
+	validatePageReferenceFromPath(t, "README.md", "", false)
+}
+
+func validatePageReferenceFromPath(t *testing.T, path string, expected string, expectedOk bool) {
+	ref, ok := pageReferenceFromPath(path)
+	if ok != expectedOk || (ok && ref.String() != expected) {
+		t.Errorf("%s: expected '%s' %v got '%s' %v", path, expected, expectedOk, ref, ok)
+	}
+}
+
+func TestShortRevisionId(t *testing.T) {
+	if id := shortRevisionId("af5626b4a114abcb82d63db7c8082c3c4756e51b"); id != "af5626b" {
+		t.Errorf("expected '%s' got '%s'", "af5626b", id)
+	}
+}
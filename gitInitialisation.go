@@ -6,13 +6,14 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"errors"
 	"os"
+	"strconv"
 )
 
 func gitCredentials(username string, passphrase string, keyPath string) (func(string, string, git.CredType) (git.ErrorCode, *git.Cred), error) {
 	errorCode, cred := git.NewCredSshKey(username, keyPath+".pub", keyPath, passphrase)
 
 	if git.ErrorCode(errorCode) != git.ErrOk {
-		return nil, errors.New("Invalid Credentials: " + string(errorCode))
+		return nil, errors.New("Invalid Credentials: " + strconv.Itoa(errorCode))
 	}
 	return func(url string, username_from_url string, allowed_types git.CredType) (git.ErrorCode, *git.Cred) {
 		return git.ErrorCode(errorCode), &cred
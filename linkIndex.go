@@ -0,0 +1,150 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// Identifies a page by its web and title.
+type PageReference struct {
+	Web   string
+	Title string
+}
+
+func (p PageReference) String() string {
+	return p.Web + "." + p.Title
+}
+
+// An index of the wiki links between pages, so the pages linking to a page
+// can be found.
+type LinkIndex struct {
+	lock     sync.RWMutex
+	links    map[PageReference][]PageReference
+	linkedBy map[PageReference]map[PageReference]bool
+}
+
+func NewLinkIndex() *LinkIndex {
+	return &LinkIndex{
+		links:    map[PageReference][]PageReference{},
+		linkedBy: map[PageReference]map[PageReference]bool{},
+	}
+}
+
+// Returns the pages linked to from body, resolving links without a web
+// against web. Escaped links (!WikiWord) are ignored.
+func pageLinks(web string, body []byte) []PageReference {
+	links := []PageReference{}
+	seen := map[PageReference]bool{}
+	for _, m := range wikiLinkMatcher.FindAllSubmatch(body, -1) {
+		if m[1] != nil {
+			continue
+		}
+		link := PageReference{Web: web, Title: string(m[3])}
+		if m[2] != nil {
+			link.Web = string(m[2])
+		}
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// Replaces the links recorded for the page from with those found in body.
+func (i *LinkIndex) Update(from PageReference, body []byte) {
+	links := pageLinks(from.Web, body)
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.remove(from)
+	i.links[from] = links
+	for _, to := range links {
+		if to == from {
+			continue
+		}
+		if i.linkedBy[to] == nil {
+			i.linkedBy[to] = map[PageReference]bool{}
+		}
+		i.linkedBy[to][from] = true
+	}
+}
+
+// Removes the links recorded for the page from.
+func (i *LinkIndex) Remove(from PageReference) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.remove(from)
+}
+
+func (i *LinkIndex) remove(from PageReference) {
+	for _, to := range i.links[from] {
+		delete(i.linkedBy[to], from)
+		if len(i.linkedBy[to]) == 0 {
+			delete(i.linkedBy, to)
+		}
+	}
+	delete(i.links, from)
+}
+
+// Returns the pages linking to the page to, sorted by web and title.
+func (i *LinkIndex) Backlinks(to PageReference) []PageReference {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	backlinks := []PageReference{}
+	for from := range i.linkedBy[to] {
+		backlinks = append(backlinks, from)
+	}
+	sort.Slice(backlinks, func(a, b int) bool {
+		return backlinks[a].String() < backlinks[b].String()
+	})
+	return backlinks
+}
+
+// Replaces each wiki link in body with the text returned by fn for the page
+// it links to, leaving the link unchanged when fn returns "". Links without
+// a web are resolved against web.
+func mapLinks(body []byte, web string, fn func(link PageReference, qualified bool) string) []byte {
+	return wikiLinkMatcher.ReplaceAllFunc(body, func(in []byte) []byte {
+		m := wikiLinkMatcher.FindSubmatch(in)
+		if m == nil || m[1] != nil {
+			return in
+		}
+		link := PageReference{Web: web, Title: string(m[3])}
+		if m[2] != nil {
+			link.Web = string(m[2])
+		}
+		if replacement := fn(link, m[2] != nil); replacement != "" {
+			return []byte(replacement)
+		}
+		return in
+	})
+}
+
+// Rewrites the links to the page from in body, a page in web, to link to to.
+func rewriteLinks(body []byte, web string, from PageReference, to PageReference) []byte {
+	return mapLinks(body, web, func(link PageReference, qualified bool) string {
+		if link != from {
+			return ""
+		}
+		if to.Web == web && !qualified {
+			return to.Title
+		}
+		return to.String()
+	})
+}
+
+// Qualifies the links without a web in body, a page in web, so they still
+// link to the same pages when the page is moved to another web. Links to
+// the page being moved, from, are rewritten to link to to.
+func qualifyLinks(body []byte, web string, from PageReference, to PageReference) []byte {
+	return mapLinks(body, web, func(link PageReference, qualified bool) string {
+		if link == from {
+			return to.Title
+		}
+		if qualified || link.Web == to.Web {
+			return ""
+		}
+		return link.String()
+	})
+}
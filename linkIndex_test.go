@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestPageLinks(t *testing.T) {
+	links := pageLinks("Main", []byte("See WebHome, Design.WebHome and !NotALink or WebHome again."))
+	expected := []PageReference{{"Main", "WebHome"}, {"Design", "WebHome"}}
+	if len(links) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, links)
+	}
+	for i := range expected {
+		if links[i] != expected[i] {
+			t.Errorf("expected %v got %v", expected[i], links[i])
+		}
+	}
+}
+
+func TestBacklinks(t *testing.T) {
+	index := NewLinkIndex()
+	requirement := PageReference{"Main", "LoginRequirement"}
+	index.Update(PageReference{"Design", "LoginDesign"}, []byte("Implements Main.LoginRequirement"))
+	index.Update(PageReference{"Main", "WebHome"}, []byte("See LoginRequirement"))
+	index.Update(requirement, []byte("Links to itself LoginRequirement"))
+
+	backlinks := index.Backlinks(requirement)
+	if len(backlinks) != 2 || backlinks[0].String() != "Design.LoginDesign" || backlinks[1].String() != "Main.WebHome" {
+		t.Errorf("expected [Design.LoginDesign Main.WebHome] got %v", backlinks)
+	}
+
+	index.Update(PageReference{"Main", "WebHome"}, []byte("No links any more"))
+	backlinks = index.Backlinks(requirement)
+	if len(backlinks) != 1 || backlinks[0].String() != "Design.LoginDesign" {
+		t.Errorf("expected [Design.LoginDesign] got %v", backlinks)
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	from := PageReference{"Main", "OldName"}
+	validateRewrite(t, rewriteLinks([]byte("See OldName and Main.OldName, not !OldName"), "Main", from, PageReference{"Main", "NewName"}),
+		"See NewName and Main.NewName, not !OldName")
+	validateRewrite(t, rewriteLinks([]byte("See OldName"), "Main", from, PageReference{"Design", "NewName"}),
+		"See Design.NewName")
+	validateRewrite(t, rewriteLinks([]byte("See Main.OldName"), "Design", from, PageReference{"Design", "NewName"}),
+		"See Design.NewName")
+}
+
+func TestQualifyLinks(t *testing.T) {
+	validateRewrite(t, qualifyLinks([]byte("See WebHome, OldName and Design.WebHome"), "Main",
+		PageReference{"Main", "OldName"}, PageReference{"Design", "NewName"}),
+		"See Main.WebHome, NewName and Design.WebHome")
+}
+
+func validateRewrite(t *testing.T, output []byte, expected string) {
+	if string(output) != expected {
+		t.Errorf("expected '%s' got '%s'", expected, output)
+	}
+}
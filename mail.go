@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Sends email, such as password reset links, to users.
+type MailSender interface {
+	Send(to string, subject string, body string) error
+}
+
+// Sends email through an SMTP server.
+type SMTPMailSender struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func NewSMTPMailSender(addr string, from string, username string, password string) *SMTPMailSender {
+	var auth smtp.Auth
+	if username != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			host = addr[:i]
+		}
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailSender{Addr: addr, From: from, Auth: auth}
+}
+
+func (s *SMTPMailSender) Send(to string, subject string, body string) error {
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{to}, mailMessage(s.From, to, subject, body))
+}
+
+// Writes email to Writer instead of sending it, or to the log when Writer
+// is nil, for trying the wiki out and for tests.
+type LogMailSender struct {
+	Writer io.Writer
+}
+
+func (s *LogMailSender) Send(to string, subject string, body string) error {
+	message := mailMessage("wiki", to, subject, body)
+	if s.Writer == nil {
+		log.Info("Not sending mail:\n" + string(message))
+		return nil
+	}
+	_, err := s.Writer.Write(message)
+	return err
+}
+
+func mailMessage(from string, to string, subject string, body string) []byte {
+	clean := func(header string) string {
+		return strings.NewReplacer("\r", "", "\n", "").Replace(header)
+	}
+	return []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		clean(from), clean(to), clean(subject), time.Now().Format(time.RFC1123Z),
+		strings.Replace(body, "\n", "\r\n", -1)))
+}
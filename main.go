@@ -26,6 +26,18 @@ func main() {
 	var cloneFromGitRepo = flag.String("clone", "", "Clone from repository")
 	var initFromGitRepo = flag.String("init", "", "Initialise from repository")
 	var originGitRepo = flag.String("origin", "", "Initialise to repository")
+	var authorHeader = flag.String("author-header", "", "Request header naming the editing user, set by a trusted proxy")
+	var trustBasicAuth = flag.Bool("trust-basic-auth", false, "Take the editing user from HTTP basic auth checked by a trusted proxy")
+	var defaultAuthor = flag.String("default-author", "Guest User <guest@example.com>", "Author of changes by unidentified users")
+	var committer = flag.String("committer", "", "Committer of changes, 'Name <email>', defaults to the author")
+	var baseURL = flag.String("url", "", "Address the wiki is published at, such as https://wiki.example.com, used in feeds and emails")
+	var smtpAddr = flag.String("smtp", "", "SMTP server for sending mail, 'host:port', mail is logged when not set")
+	var smtpFrom = flag.String("smtp-from", "wiki@localhost", "Sender of mail from the wiki")
+	var oidcIssuer = flag.String("oidc-issuer", "", "OpenID Connect provider to log in with, such as https://accounts.google.com")
+	var oidcClientID = flag.String("oidc-client-id", "", "Client id registered with the OpenID Connect provider")
+	var sessionSecret = flag.String("session-secret", os.Getenv("GOWIKI_SESSION_SECRET"), "Key signing session cookies, defaults to $GOWIKI_SESSION_SECRET")
+	var htmlPolicyName = flag.String("html-policy", "ugc", "Sanitization of HTML in pages: ugc, strict or none")
+	var trustedWebs = flag.String("trusted-webs", "", "Comma separated webs whose pages keep their raw HTML, such as Main,Design")
 	flag.Parse()
 
 	wikiRepository, err := NewFileWikiRepository(*dataDir, *cloneFromGitRepo, *initFromGitRepo, *originGitRepo)
@@ -33,9 +45,39 @@ func main() {
 		log.Fatal("Can't initialise wiki data - ", err)
 		return
 	}
+	wikiRepository.Committer = parseAuthor(*committer)
 
 	templateRenderer := NewTemplateRenderer(*tmplDir, "default")
+	templateRenderer.Policy, err = htmlPolicy(*htmlPolicyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *trustedWebs != "" {
+		templateRenderer.TrustedWebs = strings.Split(*trustedWebs, ",")
+	}
 	wiki := NewWiki(wikiRepository, templateRenderer)
+	wiki.AuthorHeader = *authorHeader
+	wiki.TrustBasicAuth = *trustBasicAuth
+	wiki.DefaultAuthor = parseAuthor(*defaultAuthor)
+	wiki.BaseURL = *baseURL
+	if *baseURL == "" {
+		log.Warn("No -url set, password reset links will not be sent.")
+	}
+	if *sessionSecret == "" {
+		log.Warn("No session secret set, logins will not survive a restart.")
+	}
+	wiki.Sessions, err = NewSessions(*sessionSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *oidcIssuer != "" {
+		wiki.OIDC = NewOIDCProvider(*oidcIssuer, *oidcClientID, os.Getenv("GOWIKI_OIDC_CLIENT_SECRET"))
+	}
+	if *smtpAddr != "" {
+		wiki.Mail = NewSMTPMailSender(*smtpAddr, *smtpFrom, os.Getenv("GOWIKI_SMTP_USERNAME"), os.Getenv("GOWIKI_SMTP_PASSWORD"))
+	} else {
+		wiki.Mail = &LogMailSender{}
+	}
 
 	port := ":" + strconv.Itoa(*ip)
 	log.Info("starting wiki engine on localhost" + port + " from directory " + *dataDir + ".")
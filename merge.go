@@ -0,0 +1,93 @@
+package main
+
+import "strings"
+
+// A change to the base text, replacing lines start to end with lines.
+type mergeHunk struct {
+	start int
+	end   int
+	lines []string
+}
+
+func diffHunks(base []string, other []string) []mergeHunk {
+	edits := diffTokens(base, other)
+	hunks := []mergeHunk{}
+	position := 0
+	for i := 0; i < len(edits); {
+		if edits[i].Op == DiffEqual {
+			position++
+			i++
+			continue
+		}
+		hunk := mergeHunk{start: position, end: position}
+		for ; i < len(edits) && edits[i].Op != DiffEqual; i++ {
+			if edits[i].Op == DiffDelete {
+				hunk.end++
+				position++
+			} else {
+				hunk.lines = append(hunk.lines, edits[i].Text)
+			}
+		}
+		hunks = append(hunks, hunk)
+	}
+	return hunks
+}
+
+func sameHunk(a mergeHunk, b mergeHunk) bool {
+	if a.start != b.start || a.end != b.end || len(a.lines) != len(b.lines) {
+		return false
+	}
+	for i := range a.lines {
+		if a.lines[i] != b.lines[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Three-way merges the changes made in ours and theirs to their common base,
+// returning false when both changed the same or adjacent lines.
+func mergeText(base string, ours string, theirs string) (string, bool) {
+	baseLines := splitLines(base)
+	a, b := diffHunks(baseLines, splitLines(ours)), diffHunks(baseLines, splitLines(theirs))
+
+	merged := []string{}
+	position := 0
+	apply := func(hunk mergeHunk) {
+		merged = append(merged, baseLines[position:hunk.start]...)
+		merged = append(merged, hunk.lines...)
+		position = hunk.end
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j >= len(b):
+			apply(a[i])
+			i++
+		case i >= len(a):
+			apply(b[j])
+			j++
+		case a[i].start <= b[j].end && b[j].start <= a[i].end:
+			if !sameHunk(a[i], b[j]) {
+				return "", false
+			}
+			apply(a[i])
+			i++
+			j++
+		case a[i].start < b[j].start:
+			apply(a[i])
+			i++
+		default:
+			apply(b[j])
+			j++
+		}
+	}
+	merged = append(merged, baseLines[position:]...)
+
+	text := strings.Join(merged, "\n")
+	if len(merged) > 0 && strings.HasSuffix(strings.Replace(theirs, "\r\n", "\n", -1), "\n") {
+		text += "\n"
+	}
+	return text, true
+}
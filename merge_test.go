@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestMergeTextSeparateChanges(t *testing.T) {
+	merged, ok := mergeText("one\ntwo\nthree\nfour\n", "one\n2\nthree\nfour\n", "one\ntwo\nthree\n4\n")
+	if !ok {
+		t.Fatalf("expected clean merge")
+	}
+	if merged != "one\n2\nthree\n4\n" {
+		t.Errorf("expected '%s' got '%s'", "one\n2\nthree\n4\n", merged)
+	}
+}
+
+func TestMergeTextSameChange(t *testing.T) {
+	merged, ok := mergeText("one\ntwo\n", "one\n2\n", "one\n2\n")
+	if !ok {
+		t.Fatalf("expected clean merge")
+	}
+	if merged != "one\n2\n" {
+		t.Errorf("expected '%s' got '%s'", "one\n2\n", merged)
+	}
+}
+
+func TestMergeTextConflict(t *testing.T) {
+	_, ok := mergeText("one\ntwo\nthree\n", "one\nTwo\nthree\n", "one\n2\nthree\n")
+	if ok {
+		t.Errorf("expected conflict")
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logs users in with an OpenID Connect identity provider, using the
+// authorization code flow with PKCE.
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Client       *http.Client
+
+	lock      sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// The parts of the provider's discovery document that are used.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// The claims of an ID token identifying the user.
+type oidcClaims struct {
+	Issuer            string          `json:"iss"`
+	Subject           string          `json:"sub"`
+	Audience          json.RawMessage `json:"aud"`
+	Expires           int64           `json:"exp"`
+	Nonce             string          `json:"nonce"`
+	Email             string          `json:"email"`
+	EmailVerified     bool            `json:"email_verified"`
+	Name              string          `json:"name"`
+	PreferredUsername string          `json:"preferred_username"`
+}
+
+func NewOIDCProvider(issuer string, clientID string, clientSecret string) *OIDCProvider {
+	return &OIDCProvider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *OIDCProvider) getJSON(url string, v interface{}) error {
+	resp, err := p.Client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Unexpected status " + resp.Status + " from " + url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Fetches the provider's discovery document the first time it is needed.
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	discovery := &oidcDiscovery{}
+	err := p.getJSON(p.Issuer+"/.well-known/openid-configuration", discovery)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.Issuer {
+		return nil, errors.New("Discovery document is for issuer '" + discovery.Issuer + "' not '" + p.Issuer + "'.")
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("Discovery document for '" + p.Issuer + "' is missing endpoints.")
+	}
+	p.discovery = discovery
+	return discovery, nil
+}
+
+// Returns the URL to send the user to to log in at the provider.
+func (p *OIDCProvider) AuthCodeURL(redirectURL string, state string, nonce string, verifier string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchanges the authorization code for an ID token, returning its verified claims.
+func (p *OIDCProvider) Exchange(redirectURL string, code string, verifier string, nonce string) (*oidcClaims, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Token request failed with " + resp.Status + ": " + string(body))
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return nil, err
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("Token response has no ID token.")
+	}
+	return p.Verify(token.IDToken, nonce)
+}
+
+// Verifies the signature and claims of an RS256 signed ID token.
+func (p *OIDCProvider) Verify(idToken string, nonce string) (*oidcClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("ID token is not a JWT.")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeJWTSegment(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, errors.New("ID token algorithm '" + header.Alg + "' is not supported.")
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, errors.New("ID token signature is invalid.")
+	}
+
+	claims := &oidcClaims{}
+	err = decodeJWTSegment(parts[1], claims)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != p.Issuer {
+		return nil, errors.New("ID token is from issuer '" + claims.Issuer + "'.")
+	}
+	if !claims.hasAudience(p.ClientID) {
+		return nil, errors.New("ID token is not for this client.")
+	}
+	if time.Now().Unix() > claims.Expires {
+		return nil, errors.New("ID token has expired.")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("ID token nonce does not match.")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token has no subject.")
+	}
+	return claims, nil
+}
+
+// The audience of a token is either a single client id or a list of them.
+func (c *oidcClaims) hasAudience(clientID string) bool {
+	var audience string
+	if json.Unmarshal(c.Audience, &audience) == nil {
+		return audience == clientID
+	}
+	var audiences []string
+	if json.Unmarshal(c.Audience, &audiences) == nil {
+		for _, a := range audiences {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, v)
+}
+
+// Returns the provider's signing key with the given id, fetching the keys
+// again when it is not known, as providers rotate them.
+func (p *OIDCProvider) key(kid string) (*rsa.PublicKey, error) {
+	p.lock.Lock()
+	key, ok := p.keys[kid]
+	p.lock.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err = p.getJSON(discovery.JWKSURI, &jwks)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	p.lock.Lock()
+	p.keys = keys
+	p.lock.Unlock()
+	key, ok = keys[kid]
+	if !ok {
+		return nil, errors.New("ID token is signed with unknown key '" + kid + "'.")
+	}
+	return key, nil
+}
+
+// Returns a random value for the state, nonce and PKCE verifier.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+const oidcCookie = "oidc"
+
+const oidcLoginMaxAge = 10 * time.Minute
+
+// The state of a login at the provider, kept in a signed cookie until the
+// provider redirects back.
+type oidcLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Next     string `json:"next"`
+}
+
+// Returns a WikiWord user name for the identity, such as JaneDoe for
+// "Jane Doe", taken from its name, preferred user name or email.
+func oidcUserName(claims *oidcClaims) string {
+	for _, candidate := range []string{claims.Name, claims.PreferredUsername, strings.Split(claims.Email, "@")[0]} {
+		words := strings.FieldsFunc(candidate, func(c rune) bool {
+			return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9')
+		})
+		name := ""
+		for _, word := range words {
+			name += strings.ToUpper(word[:1]) + strings.ToLower(word[1:])
+		}
+		if len(words) == 1 {
+			name += "User"
+		}
+		if isUserName(name) {
+			return name
+		}
+	}
+	return "OidcUser"
+}
+
+// Returns the titles of the pages in Main, reading the directory of a file
+// repository rather than listing the pages with the history of each.
+func usersWebTitles(wikiRepository WikiRepository) ([]string, error) {
+	titles := []string{}
+	if r, ok := wikiRepository.(*FileWikiRepository); ok {
+		files, err := ioutil.ReadDir(r.Root + "/" + usersWeb)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if !f.IsDir() && strings.HasSuffix(f.Name(), ".md") {
+				titles = append(titles, strings.TrimSuffix(f.Name(), ".md"))
+			}
+		}
+		return titles, nil
+	}
+	pages, err := wikiRepository.ListPages(usersWeb)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range pages {
+		titles = append(titles, info.Title)
+	}
+	return titles, nil
+}
+
+// Returns the user with the identity, creating their user page the first
+// time they log in.
+func oidcUser(wikiRepository WikiRepository, issuer string, claims *oidcClaims) (*User, error) {
+	identity := issuer + " " + claims.Subject
+	titles, err := usersWebTitles(wikiRepository)
+	if err != nil {
+		return nil, err
+	}
+	for _, title := range titles {
+		if !isUserName(title) {
+			continue
+		}
+		p, err := wikiRepository.ReadPage(usersWeb, title)
+		if err == nil && p.Meta["oidc"] == identity {
+			email, _ := p.Meta["email"].(string)
+			return &User{Name: title, Email: email}, nil
+		}
+	}
+
+	email := ""
+	if claims.EmailVerified {
+		email = claims.Email
+	}
+	name := oidcUserName(claims)
+	for i := 1; i < 100; i++ {
+		user := &User{Name: name, Email: email}
+		if i > 1 {
+			user.Name = name + strconv.Itoa(i)
+		}
+		err = createUserPage(wikiRepository, user, map[string]interface{}{"oidc": identity})
+		if err != errUserExists {
+			return user, err
+		}
+	}
+	return nil, errors.New("Unable to find a free user name for '" + name + "'.")
+}
+
+func oidcRedirectURL(wiki *Wiki, r *http.Request) string {
+	return wiki.baseURL(r) + "/login/oidc/callback"
+}
+
+// Sends the user to log in at the provider.
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	if wiki.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+	login := &oidcLogin{Next: localRedirect(r.FormValue("next"))}
+	var err error
+	for _, token := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		if err == nil {
+			*token, err = randomToken()
+		}
+	}
+	var authURL string
+	if err == nil {
+		authURL, err = wiki.OIDC.AuthCodeURL(oidcRedirectURL(wiki, r), login.State, login.Nonce, login.Verifier)
+	}
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	value, _ := json.Marshal(login)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    wiki.Sessions.encode(string(value), time.Now().Add(oidcLoginMaxAge)),
+		Path:     "/login/oidc",
+		MaxAge:   int(oidcLoginMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Completes a login when the provider redirects back with a code.
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	if wiki.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Value: "", Path: "/login/oidc", MaxAge: -1, HttpOnly: true})
+
+	login := &oidcLogin{}
+	cookie, err := r.Cookie(oidcCookie)
+	if err == nil {
+		var value string
+		value, err = wiki.Sessions.decode(cookie.Value)
+		if err == nil {
+			err = json.Unmarshal([]byte(value), login)
+		}
+	}
+	if err != nil || login.State == "" || r.FormValue("state") != login.State {
+		http.Error(w, "The login has expired or is invalid, please log in again.", http.StatusBadRequest)
+		return
+	}
+	if providerError := r.FormValue("error"); providerError != "" {
+		http.Error(w, "Login failed: "+providerError+" "+r.FormValue("error_description"), http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := wiki.OIDC.Exchange(oidcRedirectURL(wiki, r), r.FormValue("code"), login.Verifier, login.Nonce)
+	if err != nil {
+		log.Warn(err)
+		http.Error(w, "Login failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	user, err := oidcUser(wikiRepository, wiki.OIDC.Issuer, claims)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	wiki.Sessions.Start(w, r, user.Name, userPasswordHash(wikiRepository, user.Name))
+	http.Redirect(w, r, login.Next, http.StatusFound)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A local identity provider issuing ID tokens for a single user.
+type stubIdentityProvider struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	claims    map[string]interface{}
+	challenge string
+	nonce     string
+}
+
+func newStubIdentityProvider(t *testing.T) *stubIdentityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &stubIdentityProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "good-code" || base64.RawURLEncoding.EncodeToString(verifier[:]) != idp.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims := map[string]interface{}{"nonce": idp.nonce}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, claims)})
+	})
+	idp.Server = httptest.NewServer(mux)
+	idp.claims = map[string]interface{}{
+		"iss":            idp.URL,
+		"sub":            "12345",
+		"aud":            "wiki",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"name":           "Jane Doe",
+		"email":          "jane@example.com",
+		"email_verified": true,
+	}
+	return idp
+}
+
+func (idp *stubIdentityProvider) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCVerify(t *testing.T) {
+	idp := newStubIdentityProvider(t)
+	defer idp.Close()
+	provider := NewOIDCProvider(idp.URL, "wiki", "")
+
+	claims := map[string]interface{}{"nonce": "n"}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+	if verified, err := provider.Verify(idp.sign(t, claims), "n"); err != nil || verified.Subject != "12345" {
+		t.Errorf("expected token to verify got '%v'", err)
+	}
+
+	invalid := map[string]func(map[string]interface{}){
+		"audience": func(c map[string]interface{}) { c["aud"] = []string{"other"} },
+		"expired":  func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"nonce":    func(c map[string]interface{}) { c["nonce"] = "other" },
+	}
+	for name, change := range invalid {
+		c := map[string]interface{}{}
+		for k, v := range claims {
+			c[k] = v
+		}
+		change(c)
+		if _, err := provider.Verify(idp.sign(t, c), "n"); err == nil {
+			t.Errorf("expected token with wrong %s to be rejected", name)
+		}
+	}
+
+	token := idp.sign(t, claims)
+	parts := strings.Split(token, ".")
+	forged, _ := json.Marshal(map[string]interface{}{"iss": idp.URL, "sub": "admin", "aud": "wiki",
+		"exp": time.Now().Add(time.Hour).Unix(), "nonce": "n"})
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2]
+	if _, err := provider.Verify(tampered, "n"); err == nil {
+		t.Errorf("expected token with forged claims to be rejected")
+	}
+}
+
+func TestOIDCUserName(t *testing.T) {
+	names := []struct {
+		claims   oidcClaims
+		expected string
+	}{
+		{oidcClaims{Name: "Jane Doe"}, "JaneDoe"},
+		{oidcClaims{Name: "JANE van der berg"}, "JaneVanDerBerg"},
+		{oidcClaims{PreferredUsername: "jane"}, "JaneUser"},
+		{oidcClaims{Email: "jane.doe@example.com"}, "JaneDoe"},
+		{oidcClaims{}, "OidcUser"},
+		{oidcClaims{Name: "Admin Group", Email: "admin@example.com"}, "AdminUser"},
+	}
+	for _, n := range names {
+		if name := oidcUserName(&n.claims); name != n.expected {
+			t.Errorf("expected '%s' got '%s'", n.expected, name)
+		}
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newStubIdentityProvider(t)
+	defer idp.Close()
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/WebHome.md": "Home",
+		"Main/JaneDoe.md": "A page about someone else called Jane Doe",
+	})
+	defer os.RemoveAll(r.Root)
+	defer drainGitWorkQueue()
+	renderer := NewTemplateRenderer("tmpl", "default")
+	wiki := &Wiki{Repository: r, PageRenderer: renderer, BaseURL: "http://wiki.example.com",
+		OIDC: NewOIDCProvider(idp.URL, "wiki", "secret")}
+	wiki.Sessions, _ = NewSessions("secret")
+
+	login := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/login/oidc?next=/view/Main/WebHome", nil)
+		rr := httptest.NewRecorder()
+		makeWikiHandler(oidcLoginHandler, wiki, r, renderer).ServeHTTP(rr, req)
+		location, _ := url.Parse(rr.Header().Get("Location"))
+		if !strings.HasPrefix(location.String(), idp.URL+"/authorize?") ||
+			location.Query().Get("redirect_uri") != "http://wiki.example.com/login/oidc/callback" {
+			t.Fatalf("expected redirect to the provider got '%s'", location)
+		}
+		idp.challenge = location.Query().Get("code_challenge")
+		idp.nonce = location.Query().Get("nonce")
+
+		req, _ = http.NewRequest("GET", "/login/oidc/callback?code=good-code&state="+location.Query().Get("state"), nil)
+		for _, cookie := range rr.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		rr = httptest.NewRecorder()
+		makeWikiHandler(oidcCallbackHandler, wiki, r, renderer).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := login()
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/view/Main/WebHome" {
+		t.Fatalf("expected redirect after login got %v '%s'", rr.Code, rr.Body.String())
+	}
+	user, p, err := loadUser(r, "JaneDoe2")
+	if err != nil || user.Email != "jane@example.com" || p.Meta["oidc"] != idp.URL+" 12345" {
+		t.Errorf("expected user page JaneDoe2 for the identity got '%v'", err)
+	}
+
+	login()
+	if _, _, err := loadUser(r, "JaneDoe3"); err == nil {
+		t.Errorf("expected second login to use the existing user page")
+	}
+
+	req, _ := http.NewRequest("GET", "/login/oidc/callback?code=good-code&state=forged", nil)
+	rr = httptest.NewRecorder()
+	makeWikiHandler(oidcCallbackHandler, wiki, r, renderer).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected callback without login state to fail got %v", rr.Code)
+	}
+}
@@ -1,7 +1,7 @@
 package main
 
-func (p *Page) save(wikiRepository WikiRepository, web string) error {
-	return wikiRepository.WritePage(web, p)
+func (p *Page) save(wikiRepository WikiRepository, web string, edit *Edit) error {
+	return wikiRepository.WritePage(web, p, edit)
 }
 
 func loadPage(wikiRepository WikiRepository, web string, title string) (*Page, error) {
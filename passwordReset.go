@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const resetTokenMaxAge = time.Hour
+
+// Returns a token allowing the user to reset their password until expires.
+// The token is signed together with the user's current password hash, so it
+// can only be used once: setting a new password invalidates it.
+func (s *Sessions) resetToken(userName string, passwordHash string, expires time.Time) string {
+	value := base64.RawURLEncoding.EncodeToString([]byte(userName + "|" + strconv.FormatInt(expires.Unix(), 10)))
+	return value + "." + s.sign("reset|"+value+"|"+passwordHash)
+}
+
+// Returns the user a reset token made by resetToken is for, if it is valid.
+func (s *Sessions) checkResetToken(wikiRepository WikiRepository, token string) (*User, error) {
+	invalid := errors.New("The password reset link is invalid or has expired.")
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, invalid
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, invalid
+	}
+	fields := strings.Split(string(decoded), "|")
+	if len(fields) != 2 {
+		return nil, invalid
+	}
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, invalid
+	}
+	user, p, err := loadUser(wikiRepository, fields[0])
+	if err != nil {
+		return nil, invalid
+	}
+	hash, _ := p.Meta["password"].(string)
+	if !hmac.Equal([]byte(s.sign("reset|"+parts[0]+"|"+hash)), []byte(parts[1])) {
+		return nil, invalid
+	}
+	return user, nil
+}
+
+// Emails the user a link to reset their password. The link is only made
+// with the configured address, a request's Host header could send the user
+// a valid token on another site.
+func sendPasswordReset(wiki *Wiki, userName string) error {
+	if wiki.Mail == nil {
+		return errors.New("No mail sender is configured.")
+	}
+	if wiki.BaseURL == "" {
+		return errors.New("No address is configured with -url, not sending password reset links.")
+	}
+	baseURL := strings.TrimSuffix(wiki.BaseURL, "/")
+	user, p, err := loadUser(wiki.Repository, userName)
+	if err != nil {
+		return err
+	}
+	if user.Email == "" {
+		return errors.New("User '" + userName + "' has no email address.")
+	}
+	hash, _ := p.Meta["password"].(string)
+	token := wiki.Sessions.resetToken(user.Name, hash, time.Now().Add(resetTokenMaxAge))
+	link := baseURL + "/reset/confirm?token=" + url.QueryEscape(token)
+	body := "Someone asked to reset the password for " + user.Name + " on " + baseURL + ".\n\n" +
+		"To choose a new password open this link within an hour:\n\n" + link + "\n\n" +
+		"If it was not you, ignore this email and your password will not change.\n"
+	return wiki.Mail.Send(user.Email, "Reset your wiki password", body)
+}
+
+func resetFormHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	renderTemplate(w, r, templateRenderer, "reset", wiki, usersWeb, &Page{Title: "ResetPassword"}, nil)
+}
+
+func resetHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	err := sendPasswordReset(wiki, r.FormValue("name"))
+	if err != nil {
+		// not shown, so the form does not reveal which users exist
+		log.Warn(err)
+	}
+	renderTemplate(w, r, templateRenderer, "reset", wiki, usersWeb, &Page{Title: "ResetPassword"},
+		map[string]interface{}{"Sent": true})
+}
+
+func newPasswordFormHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	token := r.FormValue("token")
+	data := map[string]interface{}{"Token": token}
+	if _, err := wiki.Sessions.checkResetToken(wikiRepository, token); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		data["Error"] = err.Error()
+	}
+	renderTemplate(w, r, templateRenderer, "newpassword", wiki, usersWeb, &Page{Title: "ResetPassword"}, data)
+}
+
+func newPasswordHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	token := r.FormValue("token")
+	user, err := wiki.Sessions.checkResetToken(wikiRepository, token)
+	if err == nil && r.FormValue("password") != r.FormValue("confirm") {
+		err = errors.New("The passwords do not match.")
+	}
+	if err == nil {
+		err = setUserPassword(wikiRepository, user, r.FormValue("password"))
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, templateRenderer, "newpassword", wiki, usersWeb, &Page{Title: "ResetPassword"},
+			map[string]interface{}{"Token": token, "Error": err.Error()})
+		return
+	}
+	wiki.Sessions.Start(w, r, user.Name, userPasswordHash(wikiRepository, user.Name))
+	http.Redirect(w, r, generatePath("view", usersWeb, user.Name), http.StatusFound)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResetToken(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/JaneDoe.md": "---\nemail: jane@example.com\npassword: hash\n---\nJane"})
+	defer os.RemoveAll(r.Root)
+	sessions, _ := NewSessions("secret")
+
+	token := sessions.resetToken("JaneDoe", "hash", time.Now().Add(time.Hour))
+	if user, err := sessions.checkResetToken(r, token); err != nil || user.Name != "JaneDoe" {
+		t.Errorf("expected token for JaneDoe to be valid got '%v'", err)
+	}
+
+	tokens := map[string]string{
+		"changed password": sessions.resetToken("JaneDoe", "old hash", time.Now().Add(time.Hour)),
+		"expired":          sessions.resetToken("JaneDoe", "hash", time.Now().Add(-time.Minute)),
+		"unknown user":     sessions.resetToken("JohnSmith", "hash", time.Now().Add(time.Hour)),
+		"session cookie":   sessions.session("JaneDoe", "hash", time.Now().Add(time.Hour)),
+		"malformed":        "JaneDoe",
+	}
+	for name, token := range tokens {
+		if _, err := sessions.checkResetToken(r, token); err == nil {
+			t.Errorf("expected %s token to be rejected", name)
+		}
+	}
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{"Main/WebHome.md": "Home"})
+	defer os.RemoveAll(r.Root)
+	defer drainGitWorkQueue()
+	if _, err := registerUser(r, "JaneDoe", "jane@example.com", "old password"); err != nil {
+		t.Fatal(err)
+	}
+	mail := new(bytes.Buffer)
+	renderer := NewTemplateRenderer("tmpl", "default")
+	wiki := &Wiki{Repository: r, PageRenderer: renderer, Mail: &LogMailSender{Writer: mail}}
+	wiki.Sessions, _ = NewSessions("secret")
+	stolen := &http.Cookie{Name: sessionCookie,
+		Value: wiki.Sessions.session("JaneDoe", userPasswordHash(r, "JaneDoe"), time.Now().Add(time.Hour))}
+	sessionUser := func(cookie *http.Cookie) *User {
+		var user *User
+		req, _ := http.NewRequest("GET", "/view/Main/WebHome", nil)
+		req.AddCookie(cookie)
+		wiki.withUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user = requestUser(r)
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		return user
+	}
+	if sessionUser(stolen) == nil {
+		t.Fatalf("expected the session to log in before the reset")
+	}
+
+	reset := func() {
+		req, _ := http.NewRequest("POST", "/reset", strings.NewReader("name=JaneDoe"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Host = "evil.example.com"
+		rr := httptest.NewRecorder()
+		makeWikiHandler(resetHandler, wiki, r, renderer).ServeHTTP(rr, req)
+	}
+	reset()
+	if mail.Len() != 0 {
+		t.Errorf("expected no reset link without a configured address got '%s'", mail.String())
+	}
+	wiki.BaseURL = "https://wiki.example.com"
+	reset()
+	m := regexp.MustCompile(`https://wiki\.example\.com/reset/confirm\?token=(\S+)`).FindStringSubmatch(mail.String())
+	if m == nil || !strings.Contains(mail.String(), "To: jane@example.com") {
+		t.Fatalf("expected reset link mailed to jane@example.com in '%s'", mail.String())
+	}
+	token, _ := url.QueryUnescape(m[1])
+
+	form := url.Values{"token": {token}, "password": {"new password"}, "confirm": {"new password"}}
+	newPassword := func() int {
+		req, _ := http.NewRequest("POST", "/reset/confirm", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		makeWikiHandler(newPasswordHandler, wiki, r, renderer).ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := newPassword(); code != http.StatusFound {
+		t.Errorf("expected %v got %v", http.StatusFound, code)
+	}
+	if _, err := authenticateUser(r, "JaneDoe", "new password"); err != nil {
+		t.Errorf("expected new password to be set got '%s'", err)
+	}
+	if code := newPassword(); code != http.StatusBadRequest {
+		t.Errorf("expected token to be used only once got %v", code)
+	}
+	if sessionUser(stolen) != nil {
+		t.Errorf("expected sessions started before the reset to end")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Preferences are set in pages by bullets indented by three spaces or a tab,
+// such as "   * Set RequireAuthentication = off".
+var preferenceMatcher = regexp.MustCompile(`(?m)^(?:   |\t)+\*[ \t]+[Ss]et[ \t]+([A-Za-z][A-Za-z0-9_]*)[ \t]*=[ \t]*(.*?)[ \t]*$`)
+
+const sitePreferencesPage = "WikiPreferences"
+const webPreferencesPage = "WebPreferences"
+
+// Returns the preferences set in a page body.
+func parsePreferences(body []byte) map[string]string {
+	preferences := map[string]string{}
+	for _, m := range preferenceMatcher.FindAllSubmatch(body, -1) {
+		preferences[string(m[1])] = string(m[2])
+	}
+	return preferences
+}
+
+// Returns the preferences in effect for a page: those of the site in
+// Main.WikiPreferences, overridden by the web's WebPreferences and then by
+// the page itself. Either web or title may be empty to stop at the site or
+// web preferences.
+func readPreferences(wikiRepository WikiRepository, web string, title string) map[string]string {
+	preferences := map[string]string{}
+	pages := []PageReference{{Web: "Main", Title: sitePreferencesPage}}
+	if web != "" {
+		pages = append(pages, PageReference{Web: web, Title: webPreferencesPage})
+		if title != "" {
+			pages = append(pages, PageReference{Web: web, Title: title})
+		}
+	}
+	for _, ref := range pages {
+		p, err := wikiRepository.ReadPage(ref.Web, ref.Title)
+		if err != nil {
+			continue
+		}
+		for name, value := range parsePreferences(p.Body) {
+			preferences[name] = value
+		}
+	}
+	return preferences
+}
+
+// Returns the value of a preference that is on or off.
+func preferenceEnabled(preferences map[string]string, name string, defaultValue bool) bool {
+	switch strings.ToLower(preferences[name]) {
+	case "on", "yes", "true", "1":
+		return true
+	case "off", "no", "false", "0":
+		return false
+	}
+	return defaultValue
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParsePreferences(t *testing.T) {
+	preferences := parsePreferences([]byte("Preferences:\n" +
+		"   * Set RequireAuthentication = off\n" +
+		"\t* set Skin=print\n" +
+		"* Set NotIndented = on\n" +
+		"   * Set Empty =\n"))
+	expected := map[string]string{"RequireAuthentication": "off", "Skin": "print", "Empty": ""}
+	if len(preferences) != len(expected) {
+		t.Errorf("expected '%v' got '%v'", expected, preferences)
+	}
+	for name, value := range expected {
+		if preferences[name] != value {
+			t.Errorf("expected '%s' got '%s'", value, preferences[name])
+		}
+	}
+}
+
+func TestReadPreferencesOverride(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/WikiPreferences.md":   "   * Set RequireAuthentication = on\n   * Set Skin = default\n",
+		"Sandbox/WebPreferences.md": "   * Set RequireAuthentication = off\n",
+		"Sandbox/Private.md":        "   * Set RequireAuthentication = on\n",
+	})
+	defer os.RemoveAll(r.Root)
+	checks := []struct {
+		web, title string
+		expected   bool
+	}{
+		{"", "", true},
+		{"Main", "WebHome", true},
+		{"Sandbox", "WebHome", false},
+		{"Sandbox", "Private", true},
+	}
+	for _, c := range checks {
+		preferences := readPreferences(r, c.web, c.title)
+		if enabled := preferenceEnabled(preferences, "RequireAuthentication", false); enabled != c.expected {
+			t.Errorf("expected %v for %s.%s got %v", c.expected, c.web, c.title, enabled)
+		}
+		if preferences["Skin"] != "default" {
+			t.Errorf("expected '%s' got '%s'", "default", preferences["Skin"])
+		}
+	}
+}
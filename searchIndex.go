@@ -0,0 +1,270 @@
+package main
+
+import (
+	"html/template"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+type SearchResult struct {
+	Page    PageReference
+	Score   float64
+	Snippet template.HTML
+}
+
+// An inverted index over the titles and bodies of pages.
+type SearchIndex struct {
+	lock      sync.RWMutex
+	documents map[PageReference]*searchDocument
+	postings  map[string]map[PageReference]int
+}
+
+type searchDocument struct {
+	text        string
+	tokens      []string
+	titleTerms  map[string]bool
+	frequencies map[string]int
+}
+
+type searchQuery struct {
+	terms   []string
+	phrases [][]string
+	web     string
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		documents: map[PageReference]*searchDocument{},
+		postings:  map[string]map[PageReference]int{},
+	}
+}
+
+var searchWordMatcher = regexp.MustCompile(`[\p{L}\p{N}_]+`)
+var camelCaseMatcher = regexp.MustCompile(`[A-Z][a-z0-9]*|[a-z0-9]+`)
+
+func searchTokens(text string) []string {
+	tokens := searchWordMatcher.FindAllString(text, -1)
+	for i, token := range tokens {
+		tokens[i] = strings.ToLower(token)
+	}
+	return tokens
+}
+
+// Returns the terms of a title, including the words of a CamelCase title so
+// that searching for "home" finds WebHome.
+func titleTerms(title string) map[string]bool {
+	terms := map[string]bool{strings.ToLower(title): true}
+	for _, word := range camelCaseMatcher.FindAllString(title, -1) {
+		terms[strings.ToLower(word)] = true
+	}
+	return terms
+}
+
+func newSearchDocument(title string, body []byte) *searchDocument {
+	doc := &searchDocument{
+		text:        string(body),
+		tokens:      searchTokens(string(body)),
+		titleTerms:  titleTerms(title),
+		frequencies: map[string]int{},
+	}
+	for _, token := range doc.tokens {
+		doc.frequencies[token]++
+	}
+	for term := range doc.titleTerms {
+		doc.frequencies[term]++
+	}
+	return doc
+}
+
+// Replaces the indexed content of the page ref with body.
+func (i *SearchIndex) Update(ref PageReference, body []byte) {
+	doc := newSearchDocument(ref.Title, body)
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.remove(ref)
+	i.documents[ref] = doc
+	for term, count := range doc.frequencies {
+		if i.postings[term] == nil {
+			i.postings[term] = map[PageReference]int{}
+		}
+		i.postings[term][ref] = count
+	}
+}
+
+// Removes the page ref from the index.
+func (i *SearchIndex) Remove(ref PageReference) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.remove(ref)
+}
+
+func (i *SearchIndex) remove(ref PageReference) {
+	doc := i.documents[ref]
+	if doc == nil {
+		return
+	}
+	for term := range doc.frequencies {
+		delete(i.postings[term], ref)
+		if len(i.postings[term]) == 0 {
+			delete(i.postings, term)
+		}
+	}
+	delete(i.documents, ref)
+}
+
+var searchQueryMatcher = regexp.MustCompile(`"[^"]*"?|\S+`)
+
+// Parses a query of words, "quoted phrases" and a web:Name qualifier.
+// Every word must match for a page to be found.
+func parseSearchQuery(query string) *searchQuery {
+	q := &searchQuery{}
+	for _, part := range searchQueryMatcher.FindAllString(query, -1) {
+		if strings.HasPrefix(part, "web:") {
+			q.web = strings.TrimPrefix(part, "web:")
+			continue
+		}
+		tokens := searchTokens(part)
+		if strings.HasPrefix(part, `"`) && len(tokens) > 1 {
+			q.phrases = append(q.phrases, tokens)
+		}
+		q.terms = append(q.terms, tokens...)
+	}
+	return q
+}
+
+func containsPhrase(tokens []string, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		match := true
+		for j := range phrase {
+			if tokens[i+j] != phrase[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the pages matching query, limited to web unless it is empty or
+// the query has a web: qualifier, best matches first.
+func (i *SearchIndex) Search(query string, web string) []*SearchResult {
+	q := parseSearchQuery(query)
+	if q.web != "" {
+		web = q.web
+	}
+	results := []*SearchResult{}
+	if len(q.terms) == 0 {
+		return results
+	}
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	for ref := range i.postings[q.terms[0]] {
+		if web != "" && ref.Web != web {
+			continue
+		}
+		doc := i.documents[ref]
+		if !i.matches(ref, doc, q) {
+			continue
+		}
+		results = append(results, &SearchResult{
+			Page:    ref,
+			Score:   i.score(ref, doc, q),
+			Snippet: searchSnippet(doc.text, q),
+		})
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		if results[a].Score != results[b].Score {
+			return results[a].Score > results[b].Score
+		}
+		return results[a].Page.String() < results[b].Page.String()
+	})
+	return results
+}
+
+func (i *SearchIndex) matches(ref PageReference, doc *searchDocument, q *searchQuery) bool {
+	for _, term := range q.terms {
+		if _, ok := i.postings[term][ref]; !ok {
+			return false
+		}
+	}
+	for _, phrase := range q.phrases {
+		if !containsPhrase(doc.tokens, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+// Scores a page by tf-idf, weighting matches in the title above the body.
+func (i *SearchIndex) score(ref PageReference, doc *searchDocument, q *searchQuery) float64 {
+	score := 0.0
+	for _, term := range q.terms {
+		idf := math.Log(1 + float64(len(i.documents))/float64(len(i.postings[term])))
+		score += float64(doc.frequencies[term]) * idf / math.Sqrt(float64(len(doc.tokens)+1))
+		if doc.titleTerms[term] {
+			score += 3 * idf
+		}
+	}
+	return score
+}
+
+const snippetLength = 200
+
+// Returns an extract of text around the first match of the query, with the
+// matches highlighted.
+func searchSnippet(text string, q *searchQuery) template.HTML {
+	patterns := []string{}
+	for _, phrase := range q.phrases {
+		quoted := make([]string, len(phrase))
+		for i, word := range phrase {
+			quoted[i] = regexp.QuoteMeta(word)
+		}
+		patterns = append(patterns, strings.Join(quoted, `[^\p{L}\p{N}_]+`))
+	}
+	for _, term := range q.terms {
+		patterns = append(patterns, regexp.QuoteMeta(term))
+	}
+	matcher := regexp.MustCompile(`(?i)(` + strings.Join(patterns, "|") + `)`)
+
+	start, end := 0, len(text)
+	if first := matcher.FindStringIndex(text); first != nil && first[0] > snippetLength/4 {
+		start = first[0] - snippetLength/4
+	}
+	if end > start+snippetLength {
+		end = start + snippetLength
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start++
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	extract := text[start:end]
+
+	snippet := ""
+	if start > 0 {
+		snippet += "…"
+	}
+	position := 0
+	for _, match := range matcher.FindAllStringIndex(extract, -1) {
+		snippet += template.HTMLEscapeString(extract[position:match[0]])
+		snippet += "<mark>" + template.HTMLEscapeString(extract[match[0]:match[1]]) + "</mark>"
+		position = match[1]
+	}
+	snippet += template.HTMLEscapeString(extract[position:])
+	if end < len(text) {
+		snippet += "…"
+	}
+	return template.HTML(strings.Replace(snippet, "\n", " ", -1))
+}
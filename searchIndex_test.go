@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func createTestSearchIndex() *SearchIndex {
+	index := NewSearchIndex()
+	index.Update(PageReference{"Main", "WebHome"}, []byte("Welcome to the wiki home page."))
+	index.Update(PageReference{"Main", "LoginRequirement"}, []byte("Users must login with a password."))
+	index.Update(PageReference{"Design", "LoginDesign"}, []byte("The login page checks the password hash."))
+	return index
+}
+
+func validateSearch(t *testing.T, index *SearchIndex, query string, web string, expected ...string) {
+	results := index.Search(query, web)
+	if len(results) != len(expected) {
+		t.Errorf("'%s': expected %v got %d results", query, expected, len(results))
+		return
+	}
+	for i, result := range results {
+		if result.Page.String() != expected[i] {
+			t.Errorf("'%s': expected %v got %s at %d", query, expected, result.Page, i)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	index := createTestSearchIndex()
+	validateSearch(t, index, "password", "", "Main.LoginRequirement", "Design.LoginDesign")
+	validateSearch(t, index, "home", "", "Main.WebHome")
+	validateSearch(t, index, "login password", "Main", "Main.LoginRequirement")
+	validateSearch(t, index, "password web:Design", "", "Design.LoginDesign")
+	validateSearch(t, index, `"password hash"`, "", "Design.LoginDesign")
+	validateSearch(t, index, `"hash password"`, "")
+	validateSearch(t, index, "missing", "")
+}
+
+func TestSearchUpdateAndRemove(t *testing.T) {
+	index := createTestSearchIndex()
+	index.Update(PageReference{"Main", "WebHome"}, []byte("Now mentions a password."))
+	validateSearch(t, index, "welcome", "")
+	validateSearch(t, index, "password", "Main", "Main.WebHome", "Main.LoginRequirement")
+
+	index.Remove(PageReference{"Main", "LoginRequirement"})
+	validateSearch(t, index, "password", "Main", "Main.WebHome")
+}
+
+func TestSearchSnippet(t *testing.T) {
+	snippet := searchSnippet("Users must <login> with a password.", parseSearchQuery("login"))
+	expected := "Users must &lt;<mark>login</mark>&gt; with a password."
+	if string(snippet) != expected {
+		t.Errorf("expected '%s' got '%s'", expected, snippet)
+	}
+}
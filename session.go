@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sessionCookie = "session"
+
+const sessionMaxAge = 30 * 24 * time.Hour
+
+// Signs and checks the session cookies identifying logged in users.
+type Sessions struct {
+	Secret []byte
+	MaxAge time.Duration
+}
+
+func NewSessions(secret string) (*Sessions, error) {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &Sessions{Secret: key, MaxAge: sessionMaxAge}, nil
+}
+
+func (s *Sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Returns a signed value holding payload until expires.
+func (s *Sessions) encode(payload string, expires time.Time) string {
+	value := base64.RawURLEncoding.EncodeToString([]byte(payload + "|" + strconv.FormatInt(expires.Unix(), 10)))
+	return value + "." + s.sign(value)
+}
+
+// Returns the payload of a value made by encode, if the signature is valid
+// and it has not expired.
+func (s *Sessions) decode(value string) (string, error) {
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(s.sign(parts[0])), []byte(parts[1])) {
+		return "", errors.New("Invalid signature.")
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", err
+	}
+	separator := strings.LastIndex(string(decoded), "|")
+	if separator < 0 {
+		return "", errors.New("Invalid value.")
+	}
+	expires, err := strconv.ParseInt(string(decoded[separator+1:]), 10, 64)
+	if err != nil {
+		return "", err
+	}
+	if time.Now().Unix() > expires {
+		return "", errors.New("Expired.")
+	}
+	return string(decoded[:separator]), nil
+}
+
+// Identifies the password hash a session was started with, without
+// revealing it in the cookie.
+func (s *Sessions) passwordKey(passwordHash string) string {
+	return s.sign("session|" + passwordHash)
+}
+
+// Returns the value of a session cookie for the user until expires. It is
+// bound to the user's password hash, like reset tokens, so setting a new
+// password ends the sessions started with the old one.
+func (s *Sessions) session(userName string, passwordHash string, expires time.Time) string {
+	return s.encode(userName+"|"+s.passwordKey(passwordHash), expires)
+}
+
+// Starts a session for the user by setting the session cookie.
+func (s *Sessions) Start(w http.ResponseWriter, r *http.Request, userName string, passwordHash string) {
+	expires := time.Now().Add(s.MaxAge)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    s.session(userName, passwordHash, expires),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Ends the session by clearing the session cookie.
+func (s *Sessions) End(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// Returns the name of the user logged in by the request and the key of the
+// password hash the session was started with, or "" if there is no valid
+// session.
+func (s *Sessions) UserName(r *http.Request) (string, string) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", ""
+	}
+	payload, err := s.decode(cookie.Value)
+	if err != nil {
+		return "", ""
+	}
+	separator := strings.LastIndex(payload, "|")
+	if separator < 0 {
+		return "", ""
+	}
+	return payload[:separator], payload[separator+1:]
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	sessions, _ := NewSessions("secret")
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login", nil)
+	sessions.Start(rr, req, "JaneDoe", "hash")
+
+	req, _ = http.NewRequest("GET", "/view/Main/WebHome", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	if name, key := sessions.UserName(req); name != "JaneDoe" || key != sessions.passwordKey("hash") {
+		t.Errorf("expected '%s' got '%s'", "JaneDoe", name)
+	}
+
+	other, _ := NewSessions("other secret")
+	if name, _ := other.UserName(req); name != "" {
+		t.Errorf("expected session signed with another secret to be rejected got '%s'", name)
+	}
+}
+
+func TestSessionTampered(t *testing.T) {
+	sessions, _ := NewSessions("secret")
+	value := sessions.encode("JaneDoe", time.Now().Add(time.Hour))
+	forged := sessions.encode("AdminUser", time.Now().Add(time.Hour))
+	tampered := forged[:len(forged)-len(sessions.sign(""))] + value[len(value)-len(sessions.sign("")):]
+	if _, err := sessions.decode(tampered); err == nil {
+		t.Errorf("expected tampered session to be rejected")
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	sessions, _ := NewSessions("secret")
+	value := sessions.encode("JaneDoe", time.Now().Add(-time.Minute))
+	if _, err := sessions.decode(value); err == nil {
+		t.Errorf("expected expired session to be rejected")
+	}
+}
@@ -1,32 +1,88 @@
 package main
 
 import (
+	log "github.com/Sirupsen/logrus"
 	"github.com/russross/blackfriday"
 	"html/template"
-	//"github.com/microcosm-cc/bluemonday"
+	"github.com/microcosm-cc/bluemonday"
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/fatih/structs"
 	"io"
+	"net/http"
 	"regexp"
+	"strings"
 )
 
 type TemplateRenderer struct {
 	Root string
 	Skin string
+	// Sanitizes the HTML rendered from page bodies, nil to keep it as written.
+	Policy *bluemonday.Policy
+	// Webs whose editors are trusted, so their pages keep their raw HTML.
+	TrustedWebs []string
 }
 
 func NewTemplateRenderer(tmplDir string, skin string) *TemplateRenderer {
-	return &TemplateRenderer{Root: tmplDir, Skin: skin}
+	return &TemplateRenderer{Root: tmplDir, Skin: skin, Policy: bluemonday.UGCPolicy()}
 }
 
-func (r *TemplateRenderer) renderTemplate(w io.Writer, tmpl string, wiki *Wiki, web string, p *Page) error {
-	m := structs.Map(p)
+// Returns the sanitization policy with the given name: "ugc" allowing the
+// formatting, links and images of user content, "strict" allowing no HTML
+// at all, or "none" keeping the HTML unchanged.
+func htmlPolicy(name string) (*bluemonday.Policy, error) {
+	switch name {
+	case "ugc":
+		return bluemonday.UGCPolicy(), nil
+	case "strict":
+		return bluemonday.StrictPolicy(), nil
+	case "none":
+		return nil, nil
+	}
+	return nil, errors.New("Unknown HTML policy '" + name + "', use ugc, strict or none.")
+}
+
+// Returns true if the web is one of TrustedWebs, so its pages keep their raw
+// HTML. They are set when starting the wiki, not in a page anyone could edit.
+func (r *TemplateRenderer) trustedWeb(web string) bool {
+	for _, trusted := range r.TrustedWebs {
+		if strings.TrimSpace(trusted) == web {
+			return web != ""
+		}
+	}
+	return false
+}
+
+func (r *TemplateRenderer) renderTemplate(w io.Writer, req *http.Request, tmpl string, wiki *Wiki, web string, p *Page, data map[string]interface{}) error {
+	m := structs.Map(withoutProtectedMeta(p))
 	m["Web"] = web
 	m["Webs"] = wiki.Webs
+	for k, v := range data {
+		m[k] = v
+	}
+	policy := r.Policy
+	if policy != nil && r.trustedWeb(web) {
+		policy = nil
+	}
+
+	// page bodies are templates, so they only get the page and the viewer's
+	// name, keeping credentials and details of the viewer out of their reach
+	pageData := structs.Map(withoutProtectedMeta(p))
+	pageData["Web"] = web
+	pageData["Webs"] = wiki.Webs
+	pageData["UserName"] = ""
+	if user := requestUser(req); user != nil {
+		pageData["UserName"] = user.Name
+	}
+	if policy == nil {
+		// pages keeping their raw HTML can run scripts anyway, and need the
+		// token for their forms
+		pageData["CSRFToken"] = data["CSRFToken"]
+	}
 
 	templates := template.Must(template.New(r.Skin).
-		Funcs(template.FuncMap{"md": createMarkdownRendering(m)}).ParseGlob(r.Root + "/" + r.Skin + "/*.html"))
+		Funcs(template.FuncMap{"md": createMarkdownRendering(pageData, pageFunctions(wiki, req, web), policy)}).ParseGlob(r.Root + "/" + r.Skin + "/*.html"))
 
 	return templates.ExecuteTemplate(w, tmpl+".html", m)
 }
@@ -34,16 +90,85 @@ func (r *TemplateRenderer) renderTemplate(w io.Writer, tmpl string, wiki *Wiki,
 // http://stackoverflow.com/questions/815787/what-perl-regex-can-match-camelcase-words
 var wikiLinkMatcher = regexp.MustCompile(`(!)?\b([A-Z][a-z]+)?\.?([A-Z][a-zA-Z]*(?:[a-z][a-zA-Z]*[A-Z]|[A-Z][a-zA-Z]*[a-z])[a-zA-Z]*)\b`)
 
-func createMarkdownRendering(m map[string]interface{}) func(...interface{}) template.HTML {
+// Functions available to the templates in page bodies, such as
+// {{webIndex .Web}} listing the pages of a web the request can view.
+func pageFunctions(wiki *Wiki, r *http.Request, web string) template.FuncMap {
+	return template.FuncMap{
+		"webIndex": func(indexWeb string, sortBy ...string) string {
+			by := ""
+			if len(sortBy) > 0 {
+				by = sortBy[0]
+			}
+			pages, err := sortedPages(wiki.Repository, indexWeb, by)
+			if err != nil {
+				log.Warn(err)
+				return ""
+			}
+			viewable := []*PageInfo{}
+			for _, p := range pages {
+				if wiki.allowed(r, p.Web, p.Title, ViewAccess) {
+					viewable = append(viewable, p)
+				}
+			}
+			return webIndexMarkdown(viewable, web)
+		},
+	}
+}
+
+// Returns a markdown list linking to pages, using wiki links where the
+// title is a WikiWord.
+func webIndexMarkdown(pages []*PageInfo, web string) string {
+	index := new(bytes.Buffer)
+	for _, p := range pages {
+		link := p.Title
+		if p.Web != web {
+			link = p.Web + "." + p.Title
+		}
+		if !wikiLinkMatcher.MatchString(p.Title) {
+			link = "[" + p.Title + "](/view/" + p.Web + "/" + p.Title + ")"
+		}
+		index.WriteString("* " + link + "\n")
+	}
+	return index.String()
+}
+
+func createMarkdownRendering(m map[string]interface{}, funcs template.FuncMap, policy *bluemonday.Policy) func(...interface{}) template.HTML {
 	return func(args ...interface{}) template.HTML {
 		output := new(bytes.Buffer)
-		tmpl, _ := template.New("_").Parse(fmt.Sprintf("%s", args...))
+		tmpl, _ := template.New("_").Funcs(funcs).Parse(fmt.Sprintf("%s", args...))
 		tmpl.Execute(output, m)
-		parsed := wikiLinkMatcher.ReplaceAllFunc(output.Bytes(), wikiLinkReplacer)
-		unsafe := blackfriday.MarkdownCommon(parsed)
-		//html := bluemonday.UGCPolicy().SanitizeBytes(unsafe)
-		return template.HTML(unsafe)
+		parsed := replaceLinks(output.Bytes(), fmt.Sprint(m["Web"]), fmt.Sprint(m["Title"]))
+		html := blackfriday.MarkdownCommon(parsed)
+		if policy != nil {
+			html = policy.SanitizeBytes(html)
+		}
+		return template.HTML(html)
+	}
+}
+
+// Matches references to attachments of the page, either as the target of a
+// markdown link or image, "[plan](attachment:plan.xls)", or on their own,
+// "attachment:plan.xls".
+var attachmentLinkMatcher = regexp.MustCompile(`(\]\()?attachment:([a-zA-Z0-9][a-zA-Z0-9._-]*[a-zA-Z0-9_-])`)
+
+// Replaces attachment references and wiki links with markdown links. Wiki
+// links are not looked for inside attachment references, so attachment
+// names and urls are not broken up.
+func replaceLinks(in []byte, web string, title string) []byte {
+	out := []byte{}
+	position := 0
+	for _, m := range attachmentLinkMatcher.FindAllSubmatchIndex(in, -1) {
+		out = append(out, wikiLinkMatcher.ReplaceAllFunc(in[position:m[0]], wikiLinkReplacer)...)
+		name := string(in[m[4]:m[5]])
+		url := "/attach/" + web + "/" + title + "/" + name
+		if m[2] >= 0 {
+			out = append(out, []byte("]("+url)...)
+		} else {
+			out = append(out, []byte("["+name+"]("+url+")")...)
+		}
+		position = m[1]
 	}
+	return append(out, wikiLinkMatcher.ReplaceAllFunc(in[position:], wikiLinkReplacer)...)
 }
 
 func wikiLinkReplacer(in []byte) []byte {
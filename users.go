@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Users are pages in the users web, listed on the users page.
+const usersWeb = "Main"
+const usersPage = "WikiUsers"
+
+const minPasswordLength = 8
+
+// Page metadata that is kept out of the edit form and can not be changed by
+// editing the page.
+var protectedMeta = []string{"password", "oidc", "tokens", "email"}
+
+var validUserName = regexp.MustCompile(`^[A-Z][a-z0-9]+(?:[A-Z][a-z0-9]*)+$`)
+
+// Pages in the users web that configure the wiki, which no one can register
+// as. Neither can they register as a group, such as AdminGroup.
+var reservedUserNames = map[string]bool{sitePreferencesPage: true, webPreferencesPage: true, usersPage: true,
+	"WebHome": true, "UserRegistration": true}
+
+// Returns true if name can be the name of a user.
+func isUserName(name string) bool {
+	return validUserName.MatchString(name) && !reservedUserNames[name] && !strings.HasSuffix(name, groupSuffix)
+}
+
+type User struct {
+	Name  string
+	Email string
+}
+
+func (u *User) Author() *Author {
+	return &Author{Name: u.Name, Email: u.Email}
+}
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// Returns the user logged in by the request, or nil.
+func requestUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey).(*User)
+	return user
+}
+
+// Loads the user named by the request's API token or session into the
+// request context. Requests with an invalid token are refused.
+func (w *Wiki) withUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok {
+			user, err := authenticateAPIToken(w.Repository, token)
+			if err != nil {
+				rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(rw, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+		} else if w.Sessions != nil {
+			if name, key := w.Sessions.UserName(r); name != "" {
+				user, p, err := loadUser(w.Repository, name)
+				if err == nil {
+					// sessions end when the password changes, such as by a reset
+					hash, _ := p.Meta["password"].(string)
+					if hmac.Equal([]byte(w.Sessions.passwordKey(hash)), []byte(key)) {
+						r = r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+					}
+				}
+			}
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Paths that are always available, so anonymous users can log in.
+var publicPaths = map[string]bool{"/login": true, "/logout": true, "/login/oidc": true, "/login/oidc/callback": true,
+	"/reset": true, "/reset/confirm": true}
+
+// Returns the web and title of the page a request is for, from paths such
+// as /view/Web/Title or /feed/Web.atom. Either is empty when the request is
+// not for a single web or page.
+func requestPage(urlPath string) (string, string) {
+	if urlPath == "/register" {
+		return usersWeb, "UserRegistration"
+	}
+	if strings.HasPrefix(urlPath, "/api/v1/webs/") {
+		// /api/v1/webs/Web/pages/Title
+		urlPath = strings.Replace(strings.TrimPrefix(urlPath, "/api/v1"), "/pages/", "/", 1)
+	}
+	parts := strings.Split(strings.TrimPrefix(urlPath, "/"), "/")
+	web, title := "", ""
+	if len(parts) > 1 {
+		web = strings.TrimSuffix(parts[1], path.Ext(parts[1]))
+	}
+	if len(parts) > 2 {
+		title = parts[2]
+	}
+	if !validName.MatchString(web) {
+		return "", ""
+	}
+	if !validName.MatchString(title) {
+		title = ""
+	}
+	return web, title
+}
+
+// Returns true if the request is by a logged in user, or one identified by
+// the trusted proxy header.
+func (w *Wiki) authenticated(r *http.Request) bool {
+	return requestUser(r) != nil || (w.AuthorHeader != "" && r.Header.Get(w.AuthorHeader) != "")
+}
+
+// Sends anonymous users to log in when the preferences of the page or web
+// they asked for set RequireAuthentication.
+func (w *Wiki) withAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !w.authenticated(r) && !publicPaths[r.URL.Path] {
+			web, title := requestPage(r.URL.Path)
+			if preferenceEnabled(readPreferences(w.Repository, web, title), "RequireAuthentication", false) {
+				if (r.Method == "GET" || r.Method == "HEAD") && !isAPIRequest(r) {
+					http.Redirect(rw, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+					return
+				}
+				http.Error(rw, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Reads the user with the given name and their page.
+func loadUser(wikiRepository WikiRepository, name string) (*User, *Page, error) {
+	if !isUserName(name) {
+		return nil, nil, errors.New("'" + name + "' is not a valid user name.")
+	}
+	p, err := wikiRepository.ReadPage(usersWeb, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, hasPassword := p.Meta["password"].(string)
+	_, hasOIDC := p.Meta["oidc"].(string)
+	if !hasPassword && !hasOIDC {
+		return nil, nil, errors.New("'" + name + "' is not a user.")
+	}
+	email, _ := p.Meta["email"].(string)
+	return &User{Name: name, Email: email}, p, nil
+}
+
+// Returns the password hash of the user, "" for users without a password,
+// such as those logging in with OpenID Connect.
+func userPasswordHash(wikiRepository WikiRepository, name string) string {
+	_, p, err := loadUser(wikiRepository, name)
+	if err != nil {
+		return ""
+	}
+	hash, _ := p.Meta["password"].(string)
+	return hash
+}
+
+// Checks the password of the user with the given name.
+func authenticateUser(wikiRepository WikiRepository, name string, password string) (*User, error) {
+	user, p, err := loadUser(wikiRepository, name)
+	if err != nil {
+		return nil, errors.New("Unknown user name or wrong password.")
+	}
+	hash, _ := p.Meta["password"].(string)
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, errors.New("Unknown user name or wrong password.")
+	}
+	return user, nil
+}
+
+// Creates the page for a new user and adds them to the users page.
+func registerUser(wikiRepository WikiRepository, name string, email string, password string) (*User, error) {
+	if !validUserName.MatchString(name) {
+		return nil, errors.New("User names must be a WikiWord, such as JaneDoe.")
+	}
+	if !isUserName(name) {
+		return nil, errors.New("'" + name + "' can not be used as a user name.")
+	}
+	if _, err := wikiRepository.ReadPage(usersWeb, name); err == nil {
+		return nil, errors.New("The page for user '" + name + "' already exists.")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{Name: name, Email: strings.TrimSpace(email)}
+	err = createUserPage(wikiRepository, user, map[string]interface{}{"password": hash})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+var errUserExists = errors.New("The page for the user already exists.")
+
+// Writes the page for a new user, with meta saying how they log in, and
+// adds them to the users page. Returns errUserExists if the page exists.
+func createUserPage(wikiRepository WikiRepository, user *User, meta map[string]interface{}) error {
+	meta["parent"] = usersPage
+	meta["email"] = user.Email
+	p := &Page{Title: user.Name, Meta: meta, Body: []byte("# " + user.Name + "\n")}
+	err := p.save(wikiRepository, usersWeb, &Edit{Summary: "Registered user " + user.Name, Author: user.Author()})
+	if _, ok := err.(*EditConflictError); ok {
+		return errUserExists
+	}
+	if err != nil {
+		return err
+	}
+
+	err = addToUsersPage(wikiRepository, user)
+	if err != nil {
+		log.Warn(err)
+	}
+	return nil
+}
+
+// Replaces the password of the user.
+func setUserPassword(wikiRepository WikiRepository, user *User, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, p, err := loadUser(wikiRepository, user.Name)
+	if err != nil {
+		return err
+	}
+	p.Meta["password"] = hash
+	return p.save(wikiRepository, usersWeb, &Edit{Summary: "Changed password of " + user.Name, Author: user.Author()})
+}
+
+// Changes the email address password reset links are sent to. Users with a
+// password must give it, so a stolen session can not take the account.
+func setUserEmail(wikiRepository WikiRepository, user *User, password string, email string) error {
+	email = strings.TrimSpace(email)
+	if address, err := mail.ParseAddress(email); email != "" && (err != nil || address.Address != email) {
+		return errors.New("'" + email + "' is not a valid email address.")
+	}
+	_, p, err := loadUser(wikiRepository, user.Name)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.Meta["password"]; ok {
+		if _, err := authenticateUser(wikiRepository, user.Name, password); err != nil {
+			return errors.New("Wrong password.")
+		}
+	}
+	p.Meta["email"] = email
+	return p.save(wikiRepository, usersWeb, &Edit{Summary: "Changed email of " + user.Name, Author: user.Author()})
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", errors.New("Passwords must be at least " + strconv.Itoa(minPasswordLength) + " characters.")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func addToUsersPage(wikiRepository WikiRepository, user *User) error {
+	p, err := wikiRepository.ReadPage(usersWeb, usersPage)
+	if err != nil {
+		p = &Page{Title: usersPage, Body: []byte("Registered users of the wiki.\n\n")}
+	}
+	p.Body = append(p.Body, []byte("* "+user.Name+"\n")...)
+	return p.save(wikiRepository, usersWeb, &Edit{Summary: "Added " + user.Name + " to " + usersPage, Author: user.Author()})
+}
+
+// Returns a copy of p without its protected metadata.
+func withoutProtectedMeta(p *Page) *Page {
+	copied := *p
+	copied.Meta = map[string]interface{}{}
+	for k, v := range p.Meta {
+		copied.Meta[k] = v
+	}
+	for _, k := range protectedMeta {
+		delete(copied.Meta, k)
+	}
+	if len(copied.Meta) == 0 {
+		copied.Meta = nil
+	}
+	return &copied
+}
+
+// Returns the source of the page to show, without its protected metadata.
+func publicSource(p *Page) ([]byte, error) {
+	return pageSource(withoutProtectedMeta(p))
+}
+
+// Replaces the protected metadata of p, a page submitted by the edit form,
+// with that of the current page.
+func keepProtectedMeta(wikiRepository WikiRepository, web string, p *Page) {
+	for _, k := range protectedMeta {
+		delete(p.Meta, k)
+	}
+	current, err := wikiRepository.ReadPage(web, p.Title)
+	if err != nil {
+		return
+	}
+	for _, k := range protectedMeta {
+		if v, ok := current.Meta[k]; ok {
+			if p.Meta == nil {
+				p.Meta = map[string]interface{}{}
+			}
+			p.Meta[k] = v
+		}
+	}
+}
+
+// Returns next if it is a path on this site, to redirect to after login.
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func registerFormHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	renderTemplate(w, r, templateRenderer, "register", wiki, usersWeb, &Page{Title: "UserRegistration"}, nil)
+}
+
+func registerHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	name := r.FormValue("name")
+	email := r.FormValue("email")
+	var err error
+	if r.FormValue("password") != r.FormValue("confirm") {
+		err = errors.New("The passwords do not match.")
+	}
+	var user *User
+	if err == nil {
+		user, err = registerUser(wikiRepository, name, email, r.FormValue("password"))
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, templateRenderer, "register", wiki, usersWeb, &Page{Title: "UserRegistration"},
+			map[string]interface{}{"Error": err.Error(), "Name": name, "Email": email})
+		return
+	}
+	wiki.Sessions.Start(w, r, user.Name, userPasswordHash(wikiRepository, user.Name))
+	http.Redirect(w, r, generatePath("view", usersWeb, user.Name), http.StatusFound)
+}
+
+func loginFormHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	renderTemplate(w, r, templateRenderer, "login", wiki, usersWeb, &Page{Title: "Login"},
+		map[string]interface{}{"Next": localRedirect(r.FormValue("next")), "OIDC": wiki.OIDC != nil})
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	name := r.FormValue("name")
+	next := localRedirect(r.FormValue("next"))
+	user, err := authenticateUser(wikiRepository, name, r.FormValue("password"))
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		renderTemplate(w, r, templateRenderer, "login", wiki, usersWeb, &Page{Title: "Login"},
+			map[string]interface{}{"Error": err.Error(), "Name": name, "Next": next, "OIDC": wiki.OIDC != nil})
+		return
+	}
+	wiki.Sessions.Start(w, r, user.Name, userPasswordHash(wikiRepository, user.Name))
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	wiki.Sessions.End(w)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func changeEmailHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	user := settingsUser(w, r)
+	if user == nil {
+		return
+	}
+	err := setUserEmail(wikiRepository, user, r.FormValue("password"), r.FormValue("email"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		renderSettings(w, r, wiki, templateRenderer, user, map[string]interface{}{"Error": err.Error()})
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusFound)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegisterAndAuthenticateUser(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{"Main/WebHome.md": "Home"})
+	defer os.RemoveAll(r.Root)
+	defer drainGitWorkQueue()
+
+	user, err := registerUser(r, "JaneDoe", "jane@example.com", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "jane@example.com" {
+		t.Errorf("expected '%s' got '%s'", "jane@example.com", user.Email)
+	}
+	p, _ := r.ReadPage("Main", "JaneDoe")
+	if hash, _ := p.Meta["password"].(string); hash == "" || hash == "correct horse" {
+		t.Errorf("expected hashed password got '%s'", hash)
+	}
+	users, _ := r.ReadPage("Main", "WikiUsers")
+	if users == nil || !strings.Contains(string(users.Body), "* JaneDoe") {
+		t.Errorf("expected JaneDoe to be listed on WikiUsers")
+	}
+
+	if _, err := authenticateUser(r, "JaneDoe", "correct horse"); err != nil {
+		t.Errorf("expected login to succeed got '%s'", err)
+	}
+	if _, err := authenticateUser(r, "JaneDoe", "wrong horse"); err == nil {
+		t.Errorf("expected login with wrong password to fail")
+	}
+	if _, err := authenticateUser(r, "WebHome", "correct horse"); err == nil {
+		t.Errorf("expected login as a page that is not a user to fail")
+	}
+	if _, err := registerUser(r, "JaneDoe", "", "another password"); err == nil {
+		t.Errorf("expected registering an existing user to fail")
+	}
+}
+
+func TestRegisterUserValidation(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{"Main/WebHome.md": "Home"})
+	defer os.RemoveAll(r.Root)
+	if _, err := registerUser(r, "jane", "", "correct horse"); err == nil {
+		t.Errorf("expected a user name that is not a WikiWord to be refused")
+	}
+	for _, name := range []string{"AdminGroup", "DesignGroup", "WikiPreferences", "WikiUsers"} {
+		if _, err := registerUser(r, name, "", "correct horse"); err == nil {
+			t.Errorf("expected the reserved user name %s to be refused", name)
+		}
+	}
+	if _, err := registerUser(r, "JaneDoe", "", "short"); err == nil {
+		t.Errorf("expected a short password to be refused")
+	}
+}
+
+func TestProtectedMeta(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/JaneDoe.md": "---\nemail: jane@example.com\npassword: hash\n---\nJane"})
+	defer os.RemoveAll(r.Root)
+	current, _ := r.ReadPage("Main", "JaneDoe")
+	if _, ok := withoutProtectedMeta(current).Meta["password"]; ok {
+		t.Errorf("expected password to be removed")
+	}
+	if current.Meta["password"] != "hash" {
+		t.Errorf("expected the page not to be changed")
+	}
+
+	if source, _ := publicSource(current); strings.Contains(string(source), "hash") {
+		t.Errorf("expected password to be left out of '%s'", source)
+	}
+	output := new(bytes.Buffer)
+	current.Body = []byte("Jane {{.Meta.password}}")
+	NewTemplateRenderer("tmpl", "default").renderTemplate(output, httptest.NewRequest("GET", "/", nil), "view", &Wiki{Repository: r}, "Main", current, nil)
+	if strings.Contains(output.String(), "hash") {
+		t.Errorf("expected page body templates not to see the password")
+	}
+
+	submitted, _ := parsePage("JaneDoe", []byte("---\npassword: forged\nemail: evil@example.com\n---\nJane"))
+	keepProtectedMeta(r, "Main", submitted)
+	if submitted.Meta["password"] != "hash" {
+		t.Errorf("expected '%s' got '%v'", "hash", submitted.Meta["password"])
+	}
+	if submitted.Meta["email"] != "jane@example.com" {
+		t.Errorf("expected '%s' got '%v'", "jane@example.com", submitted.Meta["email"])
+	}
+}
+
+func TestSetUserEmail(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{"Main/WebHome.md": "Home"})
+	defer os.RemoveAll(r.Root)
+	defer drainGitWorkQueue()
+	user, _ := registerUser(r, "JaneDoe", "jane@example.com", "correct horse")
+
+	if err := setUserEmail(r, user, "wrong horse", "evil@example.com"); err == nil {
+		t.Errorf("expected changing email without the password to fail")
+	}
+	if err := setUserEmail(r, user, "correct horse", "not an address"); err == nil {
+		t.Errorf("expected an invalid email address to be refused")
+	}
+	if err := setUserEmail(r, user, "correct horse", "jane@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _, _ := loadUser(r, "JaneDoe"); changed.Email != "jane@example.org" {
+		t.Errorf("expected '%s' got '%s'", "jane@example.org", changed.Email)
+	}
+}
+
+func TestLocalRedirect(t *testing.T) {
+	redirects := map[string]string{
+		"/view/Main/WebHome":  "/view/Main/WebHome",
+		"//evil.example.com":  "/",
+		"http://example.com/": "/",
+		"":                    "/",
+	}
+	for next, expected := range redirects {
+		if redirect := localRedirect(next); redirect != expected {
+			t.Errorf("expected '%s' got '%s'", expected, redirect)
+		}
+	}
+}
+
+func TestRequestPage(t *testing.T) {
+	paths := []struct {
+		path, web, title string
+	}{
+		{"/view/Main/WebHome", "Main", "WebHome"},
+		{"/feed/Sandbox.atom", "Sandbox", ""},
+		{"/attach/Main/WebHome/notes.txt", "Main", "WebHome"},
+		{"/register", "Main", "UserRegistration"},
+		{"/api/v1/webs/Main/pages/WebHome", "Main", "WebHome"},
+		{"/search", "", ""},
+		{"/view/../etc", "", ""},
+	}
+	for _, p := range paths {
+		web, title := requestPage(p.path)
+		if web != p.web || title != p.title {
+			t.Errorf("expected '%s.%s' got '%s.%s'", p.web, p.title, web, title)
+		}
+	}
+}
+
+func TestRequireAuthentication(t *testing.T) {
+	r := createTestFileWikiRepository(t, map[string]string{
+		"Main/WikiPreferences.md":  "   * Set RequireAuthentication = on\n",
+		"Main/UserRegistration.md": "   * Set RequireAuthentication = off\n",
+		"Main/WebHome.md":          "Home",
+	})
+	defer os.RemoveAll(r.Root)
+	wiki := &Wiki{Repository: r}
+	wiki.Sessions, _ = NewSessions("secret")
+	handler := wiki.withUser(wiki.withAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	requests := []struct {
+		method, path string
+		expected     int
+	}{
+		{"GET", "/view/Main/WebHome", http.StatusFound},
+		{"POST", "/save/Main/WebHome", http.StatusUnauthorized},
+		{"GET", "/register", http.StatusOK},
+		{"GET", "/login", http.StatusOK},
+	}
+	for _, request := range requests {
+		req, _ := http.NewRequest(request.method, request.path, nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != request.expected {
+			t.Errorf("expected %v for %s got %v", request.expected, request.path, rr.Code)
+		}
+	}
+
+	ioutil.WriteFile(r.Root+"/Main/JaneDoe.md", []byte("---\npassword: hash\n---\nJane"), 0644)
+	req, _ := http.NewRequest("GET", "/view/Main/WebHome", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: wiki.Sessions.session("JaneDoe", "hash", time.Now().Add(time.Hour))})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected %v for logged in user got %v", http.StatusOK, rr.Code)
+	}
+}
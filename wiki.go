@@ -1,17 +1,26 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	log "github.com/Sirupsen/logrus"
 	"github.com/bmizerany/pat"
+	"io"
+	"mime"
 	"net/http"
+	"path"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Page struct {
-	Title string
-	Body  []byte
-	Meta  map[string]interface{}
+	Title    string
+	Body     []byte
+	Meta     map[string]interface{}
+	Revision string
 }
 
 type Web struct {
@@ -19,17 +28,101 @@ type Web struct {
 	Settings map[string]interface{}
 }
 
+type Revision struct {
+	Id         string
+	PreviousId string
+	Author     string
+	Email      string
+	When       time.Time
+	Message    string
+}
+
+// Describes a change made with WritePage.
+type Edit struct {
+	// A short description of the change, used as the commit message.
+	Summary string
+	Author  *Author
+}
+
+// The name and email of someone changing the wiki, recorded in git.
+type Author struct {
+	Name  string
+	Email string
+}
+
+// Returned by WritePage when the page was changed by someone else after the
+// revision being saved was read, and the changes could not be merged.
+type EditConflictError struct {
+	Current *Page
+}
+
+func (e *EditConflictError) Error() string {
+	return "Page '" + e.Current.Title + "' was changed while you were editing it."
+}
+
+type Attachment struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// A change to a page, made by the commit in Revision.
+type Change struct {
+	Web      string
+	Title    string
+	Revision *Revision
+	Deleted  bool
+}
+
+type PageInfo struct {
+	Web      string
+	Title    string
+	Size     int64
+	Modified time.Time
+	Author   string
+}
+
+type TrashedPage struct {
+	Web     string
+	Title   string
+	Deleted time.Time
+}
+
 type Wiki struct {
 	Repository   WikiRepository
 	PageRenderer *TemplateRenderer
 	Webs         map[string]*Web
+	// The request header set by a trusted reverse proxy to the editing user.
+	AuthorHeader string
+	// Whether HTTP basic auth user names are checked by a trusted reverse proxy.
+	TrustBasicAuth bool
+	DefaultAuthor  *Author
+	Sessions       *Sessions
+	Mail           MailSender
+	OIDC           *OIDCProvider
+	// The address the wiki is published at, for links in feeds and emails.
+	BaseURL string
 }
 
 type WikiRepository interface {
-	CreateWeb(web string) (*Web, error)
+	CreateWeb(web string, author *Author) (*Web, error)
 	LoadWebs() map[string]*Web
-	WritePage(web string, p *Page) error
+	WritePage(web string, p *Page, edit *Edit) error
 	ReadPage(web string, title string) (*Page, error)
+	ReadPageRevision(web string, title string, rev string) (*Page, *Revision, error)
+	PageHistory(web string, title string) ([]*Revision, error)
+	RevertPage(web string, title string, rev string, author *Author) (*Page, error)
+	Backlinks(web string, title string) []PageReference
+	Search(query string, web string) []*SearchResult
+	WriteAttachment(web string, title string, name string, content io.Reader, author *Author) error
+	ReadAttachment(web string, title string, name string) ([]byte, *Attachment, error)
+	ListAttachments(web string, title string) ([]*Attachment, error)
+	MovePage(web string, title string, toWeb string, toTitle string, rewriteLinks func(PageReference) bool, author *Author) error
+	DeletePage(web string, title string, author *Author) error
+	RestorePage(web string, title string, author *Author) error
+	ListTrash() ([]*TrashedPage, error)
+	ListPages(web string) ([]*PageInfo, error)
+	RecentChanges(web string, offset int, limit int) ([]*Change, error)
 }
 
 func NewWiki(wikiRepository WikiRepository, templateRenderer *TemplateRenderer) *Wiki {
@@ -45,10 +138,49 @@ func configureHTTPHandlers(wiki *Wiki, wikiRepository WikiRepository, pageRender
 	m.Get("/view", http.HandlerFunc(routeToMainWebHomeHandler))
 	m.Get("/view/:web", http.HandlerFunc(routeToWebHomeHandler))
 	m.Get("/view/:web/:title", makeHandler(viewHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/search", makeWikiHandler(searchHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/trash", makeWikiHandler(trashHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/index/:web", makeWikiHandler(indexHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/changes", makeWikiHandler(changesHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/changes/:web", makeWikiHandler(changesHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/feed.atom", makeWikiHandler(atomFeedHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/feed.rss", makeWikiHandler(rssFeedHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/feed/:web.atom", makeWikiHandler(atomFeedHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/feed/:web.rss", makeWikiHandler(rssFeedHandler, wiki, wikiRepository, pageRenderer))
 	m.Get("/edit/:web/:title", makeHandler(editHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/history/:web/:title", makeHandler(historyHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/diff/:web/:title", makeHandler(diffHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/backlinks/:web/:title", makeHandler(backlinksHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/move/:web/:title", makeHandler(moveFormHandler, wiki, wikiRepository, pageRenderer))
 	m.Post("/save/:web/:title", makeSaveHandler(saveHandler, wiki, wikiRepository))
+	m.Post("/revert/:web/:title", makeSaveHandler(revertHandler, wiki, wikiRepository))
+	m.Post("/move/:web/:title", makeSaveHandler(moveHandler, wiki, wikiRepository))
+	m.Post("/delete/:web/:title", makeSaveHandler(deleteHandler, wiki, wikiRepository))
+	m.Post("/restore/:web/:title", makeSaveHandler(restoreHandler, wiki, wikiRepository))
 	m.Post("/web/:web/:title", makeSaveHandler(createWebHandler, wiki, wikiRepository))
-	http.Handle("/", m)
+	m.Post("/attach/:web/:title", makeSaveHandler(attachHandler, wiki, wikiRepository))
+	m.Get("/attach/:web/:title/:name", makeWikiHandler(attachmentHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/register", makeWikiHandler(registerFormHandler, wiki, wikiRepository, pageRenderer))
+	m.Post("/register", makeWikiHandler(registerHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/login", makeWikiHandler(loginFormHandler, wiki, wikiRepository, pageRenderer))
+	m.Post("/login", makeWikiHandler(loginHandler, wiki, wikiRepository, pageRenderer))
+	m.Post("/logout", makeWikiHandler(logoutHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/login/oidc", makeWikiHandler(oidcLoginHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/login/oidc/callback", makeWikiHandler(oidcCallbackHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/reset", makeWikiHandler(resetFormHandler, wiki, wikiRepository, pageRenderer))
+	m.Post("/reset", makeWikiHandler(resetHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/reset/confirm", makeWikiHandler(newPasswordFormHandler, wiki, wikiRepository, pageRenderer))
+	m.Post("/reset/confirm", makeWikiHandler(newPasswordHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/settings", makeWikiHandler(settingsHandler, wiki, wikiRepository, pageRenderer))
+	m.Post("/settings/email", makeWikiHandler(changeEmailHandler, wiki, wikiRepository, pageRenderer))
+	m.Post("/settings/tokens", makeWikiHandler(createTokenHandler, wiki, wikiRepository, pageRenderer))
+	m.Post("/settings/tokens/revoke", makeWikiHandler(revokeTokenHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/api/v1/webs", makeWikiHandler(apiWebsHandler, wiki, wikiRepository, pageRenderer))
+	m.Post("/api/v1/webs", makeWikiHandler(apiCreateWebHandler, wiki, wikiRepository, pageRenderer))
+	m.Get("/api/v1/webs/:web/pages/:title", makeWikiHandler(apiPageHandler, wiki, wikiRepository, pageRenderer))
+	m.Put("/api/v1/webs/:web/pages/:title", makeWikiHandler(apiSavePageHandler, wiki, wikiRepository, pageRenderer))
+	m.Del("/api/v1/webs/:web/pages/:title", makeWikiHandler(apiDeletePageHandler, wiki, wikiRepository, pageRenderer))
+	http.Handle("/", wiki.withUser(wiki.withCSRF(wiki.withAuthentication(m))))
 }
 
 func (w *Wiki) start(address string) error {
@@ -73,8 +205,13 @@ func routeToWebHomeHandler(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-func renderTemplate(w http.ResponseWriter, r *TemplateRenderer, tmpl string, wiki *Wiki, web string, p *Page) {
-	err := r.renderTemplate(w, tmpl, wiki, web, p)
+func renderTemplate(w http.ResponseWriter, r *http.Request, templateRenderer *TemplateRenderer, tmpl string, wiki *Wiki, web string, p *Page, data map[string]interface{}) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["User"] = requestUser(r)
+	data["CSRFToken"] = wiki.Sessions.csrfToken(r)
+	err := templateRenderer.renderTemplate(w, r, tmpl, wiki, web, p, data)
 	if err != nil {
 		log.Error(err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -83,28 +220,404 @@ func renderTemplate(w http.ResponseWriter, r *TemplateRenderer, tmpl string, wik
 }
 
 func viewHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki, wikiRepository WikiRepository, templateRenderer *TemplateRenderer, web string, title string) {
+	if !wiki.authorize(w, r, web, title, ViewAccess) {
+		return
+	}
+	if rev := r.URL.Query().Get("rev"); rev != "" {
+		viewRevision(w, r, wiki, wikiRepository, templateRenderer, web, title, rev)
+		return
+	}
 	p, err := loadPage(wikiRepository, web, title)
 	if err != nil {
 		http.Redirect(w, r, generatePath("edit", web, title), http.StatusFound)
 		return
 	}
-	renderTemplate(w, templateRenderer, "view", wiki, web, p)
+	if redirect, ok := p.Meta["redirect"].(string); ok && r.URL.Query().Get("redirect") != "no" {
+		if m := validRedirect.FindStringSubmatch(redirect); m != nil {
+			http.Redirect(w, r, generatePath("view", m[1], m[2]), http.StatusFound)
+			return
+		}
+	}
+	attachments, err := wikiRepository.ListAttachments(web, title)
+	if err != nil {
+		log.Warn(err)
+	}
+	renderTemplate(w, r, templateRenderer, "view", wiki, web, p, map[string]interface{}{
+		"Backlinks":   wiki.viewablePages(r, wikiRepository.Backlinks(web, title)),
+		"Attachments": attachments,
+	})
+}
+
+var validRedirect = regexp.MustCompile(`^([a-zA-Z0-9]+)\.([a-zA-Z0-9]+)$`)
+
+func viewRevision(w http.ResponseWriter, r *http.Request, wiki *Wiki, wikiRepository WikiRepository, templateRenderer *TemplateRenderer, web string, title string, rev string) {
+	p, revision, err := wikiRepository.ReadPageRevision(web, title, rev)
+	if err != nil {
+		log.Warn(err)
+		http.NotFound(w, r)
+		return
+	}
+	renderTemplate(w, r, templateRenderer, "view", wiki, web, p, map[string]interface{}{"OldRevision": revision})
 }
 
 func editHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
 	wikiRepository WikiRepository, templateRenderer *TemplateRenderer,
 	web string, title string) {
+	// the page is shown to change it, so changing needs viewing too
+	if !wiki.authorize(w, r, web, title, ViewAccess) || !wiki.authorize(w, r, web, title, ChangeAccess) {
+		return
+	}
 	p, err := loadPage(wikiRepository, web, title)
 	if err != nil {
 		p = &Page{Title: title}
 	}
-	renderTemplate(w, templateRenderer, "edit", wiki, web, p)
+	source, err := publicSource(p)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, templateRenderer, "edit", wiki, web, p, map[string]interface{}{"Source": string(source)})
+}
+
+func historyHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer,
+	web string, title string) {
+	if !wiki.authorize(w, r, web, title, ViewAccess) {
+		return
+	}
+	revisions, err := wikiRepository.PageHistory(web, title)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, templateRenderer, "history", wiki, web, &Page{Title: title},
+		map[string]interface{}{"Revisions": revisions})
+}
+
+func moveFormHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer,
+	web string, title string) {
+	if !wiki.authorize(w, r, web, title, ViewAccess) || !wiki.authorize(w, r, web, title, ChangeAccess) {
+		return
+	}
+	p, err := loadPage(wikiRepository, web, title)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	renderTemplate(w, r, templateRenderer, "move", wiki, web, p,
+		map[string]interface{}{"Backlinks": wiki.viewablePages(r, wikiRepository.Backlinks(web, title))})
+}
+
+func backlinksHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer,
+	web string, title string) {
+	if !wiki.authorize(w, r, web, title, ViewAccess) {
+		return
+	}
+	renderTemplate(w, r, templateRenderer, "backlinks", wiki, web, &Page{Title: title},
+		map[string]interface{}{"Backlinks": wiki.viewablePages(r, wikiRepository.Backlinks(web, title))})
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	query := r.URL.Query().Get("q")
+	web := r.URL.Query().Get("web")
+	renderTemplate(w, r, templateRenderer, "search", wiki, web, &Page{Title: "Search"}, map[string]interface{}{
+		"Query":   query,
+		"Results": wiki.viewableResults(r, wikiRepository.Search(query, web)),
+	})
+}
+
+func indexHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	web := r.URL.Query().Get(":web")
+	if _, ok := wiki.Webs[web]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !wiki.authorize(w, r, web, "", ViewAccess) {
+		return
+	}
+	sortBy := r.URL.Query().Get("sort")
+	pages, err := sortedPages(wikiRepository, web, sortBy)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, templateRenderer, "index", wiki, web, &Page{Title: "WebIndex"},
+		map[string]interface{}{"Pages": pages, "Sort": sortBy})
+}
+
+// Lists the pages in web sorted by title, or most recently modified first
+// when sortBy is "modified".
+func sortedPages(wikiRepository WikiRepository, web string, sortBy string) ([]*PageInfo, error) {
+	pages, err := wikiRepository.ListPages(web)
+	if err != nil {
+		return nil, err
+	}
+	if sortBy == "modified" {
+		sort.SliceStable(pages, func(i, j int) bool {
+			return pages[i].Modified.After(pages[j].Modified)
+		})
+	} else {
+		sort.SliceStable(pages, func(i, j int) bool {
+			return pages[i].Title < pages[j].Title
+		})
+	}
+	return pages, nil
+}
+
+const changesPerPage = 50
+
+func changesHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	web := r.URL.Query().Get(":web")
+	if _, ok := wiki.Webs[web]; web != "" && !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if web != "" && !wiki.authorize(w, r, web, "", ViewAccess) {
+		return
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	// ask for one more than shown to find out if there is a next page
+	changes, err := wikiRepository.RecentChanges(web, (page-1)*changesPerPage, changesPerPage+1)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nextPage := 0
+	if len(changes) > changesPerPage {
+		changes = changes[:changesPerPage]
+		nextPage = page + 1
+	}
+	changes = wiki.viewableChanges(r, changes)
+
+	renderTemplate(w, r, templateRenderer, "changes", wiki, web, &Page{Title: "WebChanges"}, map[string]interface{}{
+		"Changes":      changes,
+		"PreviousPage": page - 1,
+		"NextPage":     nextPage,
+	})
+}
+
+func atomFeedHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	feedHandler(w, r, wiki, wikiRepository, "application/atom+xml", writeAtomFeed)
+}
+
+func rssFeedHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	feedHandler(w, r, wiki, wikiRepository, "application/rss+xml", writeRSSFeed)
+}
+
+func feedHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki, wikiRepository WikiRepository,
+	contentType string, writeFeed func(io.Writer, string, string, []*feedEntry) error) {
+	web := r.URL.Query().Get(":web")
+	if _, ok := wiki.Webs[web]; web != "" && !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if web != "" && !wiki.authorize(w, r, web, "", ViewAccess) {
+		return
+	}
+	changes, err := wikiRepository.RecentChanges(web, 0, feedEntries)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	changes = wiki.viewableChanges(r, changes)
+	baseURL := wiki.baseURL(r)
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	err = writeFeed(w, web, baseURL, feedEntriesForChanges(wikiRepository, baseURL, changes))
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// Returns the address of the wiki for absolute links, the configured base
+// URL or else the scheme and host the request was made to.
+func (w *Wiki) baseURL(r *http.Request) string {
+	if w.BaseURL != "" {
+		return strings.TrimSuffix(w.BaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func trashHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	trash, err := wikiRepository.ListTrash()
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, templateRenderer, "trash", wiki, "", &Page{Title: "Trash"},
+		map[string]interface{}{"Trash": wiki.viewableTrash(r, trash)})
+}
+
+func diffHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer,
+	web string, title string) {
+	if !wiki.authorize(w, r, web, title, ViewAccess) {
+		return
+	}
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if from == "" {
+		http.Error(w, "Missing revision to diff from", http.StatusBadRequest)
+		return
+	}
+
+	fromPage, fromRevision, err := wikiRepository.ReadPageRevision(web, title, from)
+	if err != nil {
+		log.Warn(err)
+		http.NotFound(w, r)
+		return
+	}
+
+	var toPage *Page
+	var toRevision *Revision
+	if to == "" {
+		toPage, err = loadPage(wikiRepository, web, title)
+	} else {
+		toPage, toRevision, err = wikiRepository.ReadPageRevision(web, title, to)
+	}
+	if err != nil {
+		log.Warn(err)
+		http.NotFound(w, r)
+		return
+	}
+
+	fromSource, err := publicSource(fromPage)
+	var toSource []byte
+	if err == nil {
+		toSource, err = publicSource(toPage)
+	}
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, templateRenderer, "diff", wiki, web, toPage, map[string]interface{}{
+		"Diff":         diffText(string(fromSource), string(toSource)),
+		"FromRevision": fromRevision,
+		"ToRevision":   toRevision,
+		"SideBySide":   r.URL.Query().Get("mode") == "side",
+	})
 }
 
 func saveHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki, wikiRepository WikiRepository, web string, title string) {
+	// conflicts show the current page, so saving needs viewing too
+	if !wiki.authorize(w, r, web, title, ViewAccess) || !wiki.authorize(w, r, web, title, ChangeAccess) {
+		return
+	}
 	body := r.FormValue("body")
-	p := &Page{Title: title, Body: []byte(body)}
-	err := p.save(wikiRepository, web)
+	p, err := parsePage(title, []byte(body))
+	if err != nil {
+		http.Error(w, "Invalid page metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	keepProtectedMeta(wikiRepository, web, p)
+	p.Revision = r.FormValue("revision")
+	summary := r.FormValue("summary")
+	err = p.save(wikiRepository, web, &Edit{Summary: summary, Author: wiki.requestAuthor(r)})
+	if conflict, ok := err.(*EditConflictError); ok {
+		current, _ := publicSource(conflict.Current)
+		w.WriteHeader(http.StatusConflict)
+		renderTemplate(w, r, wiki.PageRenderer, "conflict", wiki, web, p, map[string]interface{}{
+			"Current": conflict.Current,
+			"Source":  body,
+			"Summary": summary,
+			"Diff":    diffText(string(current), body),
+		})
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, generatePath("view", web, title), http.StatusFound)
+}
+
+func revertHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki, wikiRepository WikiRepository, web string, title string) {
+	if !wiki.authorize(w, r, web, title, ChangeAccess) {
+		return
+	}
+	rev := r.FormValue("rev")
+	if rev == "" {
+		http.Error(w, "Missing revision to revert to", http.StatusBadRequest)
+		return
+	}
+	_, err := wikiRepository.RevertPage(web, title, rev, wiki.requestAuthor(r))
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, generatePath("view", web, title), http.StatusFound)
+}
+
+func moveHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki, wikiRepository WikiRepository, web string, title string) {
+	if !wiki.authorize(w, r, web, title, ChangeAccess) {
+		return
+	}
+	toWeb := r.FormValue("web")
+	toTitle := r.FormValue("title")
+	if _, ok := wiki.Webs[toWeb]; !ok || !validName.MatchString(toTitle) {
+		http.Error(w, "Bad Page Name "+toWeb+"."+toTitle, http.StatusBadRequest)
+		return
+	}
+	if !wiki.authorize(w, r, toWeb, "", ChangeAccess) {
+		return
+	}
+	var rewrite func(PageReference) bool
+	if r.FormValue("rewrite") == "on" {
+		// only in the pages the mover could have changed themselves
+		rewrite = func(ref PageReference) bool { return wiki.allowed(r, ref.Web, ref.Title, ChangeAccess) }
+	}
+	err := wikiRepository.MovePage(web, title, toWeb, toTitle, rewrite, wiki.requestAuthor(r))
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, generatePath("view", toWeb, toTitle), http.StatusFound)
+}
+
+func deleteHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki, wikiRepository WikiRepository, web string, title string) {
+	if !wiki.authorize(w, r, web, title, ChangeAccess) {
+		return
+	}
+	err := wikiRepository.DeletePage(web, title, wiki.requestAuthor(r))
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, generatePath("view", web, "WebHome"), http.StatusFound)
+}
+
+func restoreHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki, wikiRepository WikiRepository, web string, title string) {
+	if !wiki.authorize(w, r, web, title, ChangeAccess) {
+		return
+	}
+	err := wikiRepository.RestorePage(web, title, wiki.requestAuthor(r))
 	if err != nil {
 		log.Error(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -113,16 +626,87 @@ func saveHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki, wikiReposit
 	http.Redirect(w, r, generatePath("view", web, title), http.StatusFound)
 }
 
+const maxAttachmentSize = 32 << 20
+
+var validAttachmentName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+func attachHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki, wikiRepository WikiRepository, web string, title string) {
+	if !wiki.authorize(w, r, web, title, ChangeAccess) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing or too large attachment: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	name := path.Base(strings.Replace(header.Filename, "\\", "/", -1))
+	if !validAttachmentName.MatchString(name) {
+		http.Error(w, "Bad Attachment Name "+name, http.StatusBadRequest)
+		return
+	}
+	err = wikiRepository.WriteAttachment(web, title, name, file, wiki.requestAuthor(r))
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, generatePath("view", web, title), http.StatusFound)
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+// Types the browser would render as active content, which are downloaded
+// instead of shown.
+var downloadOnlyTypes = []string{"text/html", "image/svg+xml", "application/xhtml+xml", "text/xml", "application/xml"}
+
+func attachmentHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki,
+	wikiRepository WikiRepository, templateRenderer *TemplateRenderer) {
+	web := r.URL.Query().Get(":web")
+	title := r.URL.Query().Get(":title")
+	name := r.URL.Query().Get(":name")
+	if !validName.MatchString(web) || !validName.MatchString(title) || !validAttachmentName.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+	if !wiki.authorize(w, r, web, title, ViewAccess) {
+		return
+	}
+	content, attachment, err := wikiRepository.ReadAttachment(web, title, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	for _, t := range downloadOnlyTypes {
+		if strings.HasPrefix(contentType, t) {
+			w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, attachment.Modified, bytes.NewReader(content))
+}
+
 var validWeb = regexp.MustCompile(`^[A-Z][a-z]+$`)
 
 func createWebHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki, wikiRepository WikiRepository, web string, title string) {
+	if !wiki.authorize(w, r, "", "", ChangeAccess) {
+		return
+	}
 	name := r.FormValue("name")
 	if !validWeb.MatchString(name) {
 		log.Error("Bad Web Name " + name)
 		http.Error(w, "Bad Web Name "+name, http.StatusBadRequest)
 		return
 	}
-	webDefinition, err := wikiRepository.CreateWeb(name)
+	webDefinition, err := wikiRepository.CreateWeb(name, wiki.requestAuthor(r))
 	if err != nil {
 		log.Error(err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -132,7 +716,7 @@ func createWebHandler(w http.ResponseWriter, r *http.Request, wiki *Wiki, wikiRe
 	http.Redirect(w, r, generatePath("view", name, "WebHome"), http.StatusFound)
 }
 
-var validPath = regexp.MustCompile(`^/(edit|save|view|web)/([a-zA-Z0-9]+)/([a-zA-Z0-9]+)$`)
+var validPath = regexp.MustCompile(`^/(edit|save|view|web|history|diff|revert|backlinks|attach|move|delete|restore)/([a-zA-Z0-9]+)/([a-zA-Z0-9]+)$`)
 
 func parseTitleFromURL(path string) (string, string, error) {
 	m := validPath.FindStringSubmatch(path)
@@ -154,6 +738,14 @@ func makeHandler(fn func(http.ResponseWriter, *http.Request, *Wiki, WikiReposito
 	}
 }
 
+// Creates a handler for requests that are not for a single page.
+func makeWikiHandler(fn func(http.ResponseWriter, *http.Request, *Wiki, WikiRepository, *TemplateRenderer),
+	wiki *Wiki, wikiRepository WikiRepository, templateRenderer *TemplateRenderer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fn(w, r, wiki, wikiRepository, templateRenderer)
+	}
+}
+
 func makeSaveHandler(fn func(http.ResponseWriter, *http.Request, *Wiki, WikiRepository, string, string),
 	wiki *Wiki, wikiRepository WikiRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -3,6 +3,8 @@ package main
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -22,10 +24,17 @@ func TestParseWebAndTitleFromURL(t *testing.T) {
 }
 
 func makeViewRequest(wikiRepository WikiRepository) *httptest.ResponseRecorder {
-	req, _ := http.NewRequest("GET", "/view/Main/WebPage", nil)
+	return makeRequest(wikiRepository, viewHandler, "/view/Main/WebPage")
+}
+
+func makeRequest(wikiRepository WikiRepository,
+	fn func(http.ResponseWriter, *http.Request, *Wiki, WikiRepository, *TemplateRenderer, string, string),
+	url string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", url, nil)
 	rr := httptest.NewRecorder()
 	renderer := NewTemplateRenderer("tmpl", "default")
-	handler := http.HandlerFunc(makeHandler(viewHandler, wikiRepository, renderer))
+	wiki := &Wiki{Repository: wikiRepository, PageRenderer: renderer, Webs: wikiRepository.LoadWebs()}
+	handler := http.HandlerFunc(makeHandler(fn, wiki, wikiRepository, renderer))
 	handler.ServeHTTP(rr, req)
 	return rr
 }
@@ -33,7 +42,7 @@ func makeViewRequest(wikiRepository WikiRepository) *httptest.ResponseRecorder {
 func TestViewFound(t *testing.T) {
 	rr := makeViewRequest(fakeWikiRepositoryWithFile)
 	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("expected '%s' got '%s'", http.StatusOK, status)
+		t.Errorf("expected %v got %v", http.StatusOK, status)
 	}
 }
 
@@ -47,3 +56,123 @@ func TestViewNotFound(t *testing.T) {
 		t.Errorf("expected '%s' got '%s'", "/edit/Main/WebPage", moved)
 	}
 }
+
+func TestViewOldRevision(t *testing.T) {
+	rr := makeRequest(fakeWikiRepositoryWithFile, viewHandler, "/view/Main/WebPage?rev=abc1234")
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected %v got %v", http.StatusOK, status)
+	}
+
+	if !strings.Contains(rr.Body.String(), "old revision") {
+		t.Errorf("expected old revision banner in '%s'", rr.Body.String())
+	}
+}
+
+func TestViewOldRevisionNotFound(t *testing.T) {
+	rr := makeRequest(fakeWikiRepositoryNoFile, viewHandler, "/view/Main/WebPage?rev=abc1234")
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("expected %v got %v", http.StatusNotFound, status)
+	}
+}
+
+func TestDiffRevisions(t *testing.T) {
+	rr := makeRequest(fakeWikiRepositoryWithFile, diffHandler, "/diff/Main/WebPage?from=abc1234&to=def5678&mode=side")
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("expected %v got %v", http.StatusOK, status)
+	}
+}
+
+func TestDiffMissingFrom(t *testing.T) {
+	rr := makeRequest(fakeWikiRepositoryWithFile, diffHandler, "/diff/Main/WebPage")
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("expected %v got %v", http.StatusBadRequest, status)
+	}
+}
+
+func makePostRequest(wikiRepository WikiRepository,
+	fn func(http.ResponseWriter, *http.Request, *Wiki, WikiRepository, string, string),
+	url string, form url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", url, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	renderer := NewTemplateRenderer("tmpl", "default")
+	wiki := &Wiki{Repository: wikiRepository, PageRenderer: renderer, Webs: wikiRepository.LoadWebs()}
+	handler := http.HandlerFunc(makeSaveHandler(fn, wiki, wikiRepository))
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRevert(t *testing.T) {
+	rr := makePostRequest(fakeWikiRepositoryWithFile, revertHandler, "/revert/Main/WebPage", url.Values{"rev": {"abc1234"}})
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("expected %v got %v", http.StatusFound, status)
+	}
+
+	if moved := rr.HeaderMap.Get("Location"); moved != "/view/Main/WebPage" {
+		t.Errorf("expected '%s' got '%s'", "/view/Main/WebPage", moved)
+	}
+}
+
+func TestRevertMissingRevision(t *testing.T) {
+	rr := makePostRequest(fakeWikiRepositoryWithFile, revertHandler, "/revert/Main/WebPage", url.Values{})
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("expected %v got %v", http.StatusBadRequest, status)
+	}
+}
+
+func TestSaveConflict(t *testing.T) {
+	rr := makePostRequest(fakeWikiRepositoryWithConflict, saveHandler, "/save/Main/WebPage",
+		url.Values{"body": {"My changes"}, "revision": {"abc1234"}})
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("expected %v got %v", http.StatusConflict, status)
+	}
+
+	if !strings.Contains(rr.Body.String(), `value="def5678"`) {
+		t.Errorf("expected form to carry the current revision in '%s'", rr.Body.String())
+	}
+}
+
+func TestChangesPaging(t *testing.T) {
+	wiki := &Wiki{Repository: fakeWikiRepositoryWithFile, Webs: fakeWikiRepositoryWithFile.LoadWebs()}
+	handler := makeWikiHandler(changesHandler, wiki, fakeWikiRepositoryWithFile, NewTemplateRenderer("tmpl", "default"))
+
+	req, _ := http.NewRequest("GET", "/changes", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), `href="?page=2"`) {
+		t.Errorf("expected link to older changes in '%s'", rr.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/changes?page=2", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if strings.Contains(rr.Body.String(), `href="?page=3"`) || !strings.Contains(rr.Body.String(), `href="?page=1"`) {
+		t.Errorf("expected only link to newer changes in '%s'", rr.Body.String())
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	wiki := &Wiki{Repository: fakeWikiRepositoryWithFile, Webs: fakeWikiRepositoryWithFile.LoadWebs()}
+	renderer := NewTemplateRenderer("tmpl", "default")
+	feeds := []struct {
+		handler func(http.ResponseWriter, *http.Request, *Wiki, WikiRepository, *TemplateRenderer)
+		url     string
+		root    string
+	}{
+		{atomFeedHandler, "/feed.atom", "<feed"},
+		{rssFeedHandler, "/feed.rss", "<rss"},
+	}
+	for _, feed := range feeds {
+		req, _ := http.NewRequest("GET", feed.url, nil)
+		req.Host = "wiki.example.com"
+		rr := httptest.NewRecorder()
+		makeWikiHandler(feed.handler, wiki, fakeWikiRepositoryWithFile, renderer).ServeHTTP(rr, req)
+		body := rr.Body.String()
+		if !strings.Contains(body, feed.root) || strings.Count(body, "Main/WebHome.md updated") != feedEntries {
+			t.Errorf("expected %d entries in '%s'", feedEntries, body)
+		}
+		if !strings.Contains(body, "http://wiki.example.com/view/Main/WebHome") {
+			t.Errorf("expected absolute link to page in '%s'", body)
+		}
+	}
+}